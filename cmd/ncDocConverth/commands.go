@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/credentials"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A subcommand of the program. The remaining arguments after the
+// name of the command are given. The exit code is returned
+type command func(config *models.WebConfig, args []string) int
+
+var commands = map[string]command{
+	"state":    stateCommand,
+	"login":    loginCommand,
+	"logout":   logoutCommand,
+	"validate": validateCommand,
+	"schema":   schemaCommand,
+	"hash":     hashCommand,
+}
+
+// Runs the subcommand with the name of the first argument
+func runCommand(config *models.WebConfig, args []string) int {
+	cmd, exists := commands[args[0]]
+	if !exists {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'. Available commands: %s\n", args[0], strings.Join(names, ", "))
+		return 2
+	}
+
+	return cmd(config, args[1:])
+}
+
+// Prints the saved state of the converted documents
+func stateCommand(config *models.WebConfig, args []string) int {
+	flags := flag.NewFlagSet("state", flag.ExitOnError)
+	jobFilter := flags.String("job", "", "Only print jobs whose key contains the given text")
+	printJson := flags.Bool("json", false, "Print the state as JSON")
+	flags.Parse(args)
+
+	store, err := state.Open(config.Server.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	jobs := store.Jobs()
+	sort.Strings(jobs)
+	rtc := make(map[string][]state.Entry)
+	for _, job := range jobs {
+		if !strings.Contains(job, *jobFilter) {
+			continue
+		}
+
+		for _, entry := range store.Entries(job) {
+			rtc[job] = append(rtc[job], entry)
+		}
+		sort.Slice(rtc[job], func(i, j int) bool {
+			return rtc[job][i].SourcePath < rtc[job][j].SourcePath
+		})
+	}
+
+	if *printJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rtc); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("State file: %s\n", store.Path())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, job := range jobs {
+		entries, exists := rtc[job]
+		if !exists {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n%s (%d documents)\n", job, len(entries))
+		fmt.Fprintln(w, "SOURCE ID\tSOURCE\tDESTINATION\tETAG\tSIZE\tCONVERTED")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+				entry.SourceID, entry.SourcePath, entry.Destination, entry.ETag, entry.Size,
+				entry.ConvertedAt.Local().Format(time.RFC3339),
+			)
+		}
+	}
+	w.Flush()
+
+	return 0
+}
+
+// Checks the job file for errors. All problems are printed at once.
+// The job file can also be given as the first argument
+func validateCommand(config *models.WebConfig, args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	jobFile := flags.String("file", config.Server.JobFile, "Path of the job file to validate")
+	oneShot := flags.Bool("oneShot", config.Server.OneShot, "Jobs without an execution (cron expression) are valid")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		*jobFile = flags.Arg(0)
+	}
+
+	users, err := models.ParseConvertUsers(*jobFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+
+	if store, err := credentials.Open(config.Server.DataDir); err == nil {
+		store.Apply(users)
+	}
+	if err := users.Validate(!*oneShot); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: the job file '%s' is invalid:\n%s\n", *jobFile, err)
+		return 1
+	}
+
+	fmt.Printf("The job file '%s' is valid\n", *jobFile)
+	return 0
+}
+
+// Prints the JSON schema of the job file
+func schemaCommand(config *models.WebConfig, args []string) int {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	output := flags.String("output", "", "Write the schema to the given file instead of printing it")
+	flags.Parse(args)
+
+	content, err := json.MarshalIndent(models.JobFileSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	content = append(content, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(content)
+	} else if err := os.WriteFile(*output, content, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// Creates the hashes for the authentication of the API (config.yaml).
+// "token" generates a new random token, "password" hashes the password read from stdin
+func hashCommand(config *models.WebConfig, args []string) int {
+	if len(args) != 1 || (args[0] != "token" && args[0] != "password") {
+		fmt.Fprintln(os.Stderr, "Usage: ncDocConverth hash token|password")
+		return 2
+	}
+
+	if args[0] == "token" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		token := base64.RawURLEncoding.EncodeToString(random)
+		hash := sha256.Sum256([]byte(token))
+
+		fmt.Printf("Token: %s\nHash:  %s\n", token, hex.EncodeToString(hash[:]))
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "\nNo password given", err)
+		return 1
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(hash))
+	return 0
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/credentials"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Obtains an app password with the login flow of nextcloud
+func loginCommand(config *models.WebConfig, args []string) int {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	server := flags.String("url", "", "Base URL of nextcloud (https://cloud.myDomain.de)")
+	store := flags.String("store", "credentials", "Where to save the app password: 'credentials' (credentials.json inside the data directory) or 'jobfile' (password of the user in the job file)")
+	flags.Parse(args)
+
+	if *server == "" {
+		fmt.Fprintln(os.Stderr, "No url of nextcloud given (--url)")
+		return 2
+	}
+	if *store != "credentials" && *store != "jobfile" {
+		fmt.Fprintf(os.Stderr, "Invalid store '%s'. Expected 'credentials' or 'jobfile'\n", *store)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Minute)
+	defer cancel()
+
+	flow, err := nextcloud.StartLoginFlow(ctx, *server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the login flow: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Open the following URL in your browser and grant the access:\n\n  %s\n\nWaiting for the login...\n", flow.LoginURL)
+
+	appPassword, err := flow.Wait(ctx, 2*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The login failed: %s\n", err)
+		return 1
+	}
+
+	if *store == "jobfile" {
+		err = setJobFilePassword(config.Server.JobFile, *server, appPassword.LoginName, appPassword.AppPassword)
+	} else {
+		err = saveCredential(config.Server.DataDir, credentials.Credential{
+			Server:      *server,
+			LoginName:   appPassword.LoginName,
+			AppPassword: appPassword.AppPassword,
+			CreatedAt:   time.Now(),
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save the app password: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Logged in as %s\n", appPassword.LoginName)
+	return 0
+}
+
+// Revokes the app password of a user and removes it
+func logoutCommand(config *models.WebConfig, args []string) int {
+	flags := flag.NewFlagSet("logout", flag.ExitOnError)
+	server := flags.String("url", "", "Base URL of nextcloud (https://cloud.myDomain.de)")
+	user := flags.String("user", "", "Name of the user to log out")
+	store := flags.String("store", "credentials", "Where the app password is saved: 'credentials' or 'jobfile'")
+	flags.Parse(args)
+
+	if *server == "" || *user == "" {
+		fmt.Fprintln(os.Stderr, "No url of nextcloud (--url) or user (--user) given")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	switch *store {
+	case "credentials":
+		credentialStore, err := credentials.Open(config.Server.DataDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		credential, exists := credentialStore.Get(*server, *user)
+		if !exists {
+			fmt.Fprintf(os.Stderr, "No app password saved for %s on %s\n", *user, *server)
+			return 1
+		}
+
+		if err := nextcloud.RevokeAppPassword(ctx, *server, credential.LoginName, credential.AppPassword); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke the app password: %s\n", err)
+			return 1
+		}
+		credentialStore.Delete(*server, *user)
+		if err := credentialStore.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "jobfile":
+		users, err := models.ParseConvertUsers(config.Server.JobFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		password := ""
+		for _, ncUser := range users.Users {
+			if strings.TrimSuffix(ncUser.NextcloudBaseUrl, "/") == strings.TrimSuffix(*server, "/") && strings.EqualFold(ncUser.Username, *user) {
+				password = string(ncUser.Password)
+			}
+		}
+		if password == "" {
+			fmt.Fprintf(os.Stderr, "No password found for %s on %s in the job file\n", *user, *server)
+			return 1
+		}
+
+		if err := nextcloud.RevokeAppPassword(ctx, *server, *user, password); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke the app password: %s\n", err)
+			return 1
+		}
+		// A referenced secret has to be removed by the user
+		if err := setJobFilePassword(config.Server.JobFile, *server, *user, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "The app password was revoked but not removed from the job file: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid store '%s'. Expected 'credentials' or 'jobfile'\n", *store)
+		return 2
+	}
+
+	fmt.Printf("Revoked the app password of %s\n", *user)
+	return 0
+}
+
+// Adds the credential to the store inside the data directory
+func saveCredential(dataDir string, credential credentials.Credential) error {
+	store, err := credentials.Open(dataDir)
+	if err != nil {
+		return err
+	}
+	store.Set(credential)
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("The app password was saved in %s\n", store.Path())
+	return nil
+}
+
+// Sets the password of the user in the job file. Only the value of the password is replaced,
+// so that the formatting of the file is kept. If the job file does not exist, it is created.
+// A password that references a secret is never overwritten
+func setJobFilePassword(jobFile string, server string, loginName string, password string) error {
+	if models.GetJobFileFormat(jobFile) != models.JSONJobFile {
+		return fmt.Errorf("the password can only be saved in a JSON job file. Use the store 'credentials' instead")
+	}
+
+	content, err := os.ReadFile(jobFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	value, err := json.Marshal(password)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		content, err = json.MarshalIndent(map[string]interface{}{
+			"nextcloudUsers": []interface{}{
+				map[string]interface{}{
+					"nextcloudUrl": server,
+					"username":     loginName,
+					"password":     password,
+					"jobs":         []interface{}{},
+				},
+			},
+		}, "", "    ")
+		if err != nil {
+			return err
+		}
+	} else {
+		users, err := findJobFileUsers(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse the job file '%s': %s", jobFile, err)
+		}
+
+		var user *jobFileUser
+		for i := range users {
+			if strings.TrimSuffix(users[i].url, "/") == strings.TrimSuffix(server, "/") && strings.EqualFold(users[i].username, loginName) {
+				user = &users[i]
+			}
+		}
+		if user == nil {
+			return fmt.Errorf("the user %s of %s is not contained in the job file '%s'. Add the user first or use the store 'credentials'", loginName, server, jobFile)
+		}
+
+		var current string
+		if json.Unmarshal(user.password, &current) == nil && models.IsSecretReference(current) {
+			return fmt.Errorf("the password of %s references the secret '%s' and is not overwritten. Update the secret instead", loginName, current)
+		}
+
+		var replacement []byte
+		if user.passwordStart < 0 {
+			// Insert the password as the first field of the user
+			replacement = append([]byte(`"password": `), value...)
+			replacement = append(replacement, ',')
+			user.passwordStart, user.passwordEnd = user.objectStart, user.objectStart
+		} else {
+			replacement = value
+		}
+		content = append(content[:user.passwordStart:user.passwordStart], append(replacement, content[user.passwordEnd:]...)...)
+	}
+
+	if err := os.WriteFile(jobFile, content, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("The password was saved in %s\n", jobFile)
+	return nil
+}
+
+// A user inside the JSON job file
+type jobFileUser struct {
+	url      string
+	username string
+	// Raw value of the password
+	password json.RawMessage
+
+	// Offset after the opening brace of the user
+	objectStart int64
+	// Offsets of the password value (-1 if not given)
+	passwordStart int64
+	passwordEnd   int64
+}
+
+// Returns the users of the JSON job file with the offsets of their password
+func findJobFileUsers(content []byte) ([]jobFileUser, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	rtc := []jobFileUser{}
+
+	// Reads the next token and checks if it's the given delimiter
+	expect := func(delim json.Delim) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if token != delim {
+			return fmt.Errorf("expected '%s' at offset %d", delim, dec.InputOffset())
+		}
+		return nil
+	}
+	skip := func() error {
+		var raw json.RawMessage
+		return dec.Decode(&raw)
+	}
+
+	if err := expect('{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "nextcloudUsers" {
+			if err := skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := expect('['); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			if err := expect('{'); err != nil {
+				return nil, err
+			}
+			user := jobFileUser{objectStart: dec.InputOffset(), passwordStart: -1, passwordEnd: -1}
+
+			for dec.More() {
+				field, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				var raw json.RawMessage
+				if err := dec.Decode(&raw); err != nil {
+					return nil, err
+				}
+				switch field {
+				case "nextcloudUrl":
+					json.Unmarshal(raw, &user.url)
+				case "username":
+					json.Unmarshal(raw, &user.username)
+				case "password":
+					user.password = raw
+					user.passwordEnd = dec.InputOffset()
+					user.passwordStart = user.passwordEnd - int64(len(raw))
+				}
+			}
+			if err := expect('}'); err != nil {
+				return nil, err
+			}
+			rtc = append(rtc, user)
+		}
+		if err := expect(']'); err != nil {
+			return nil, err
+		}
+	}
+
+	return rtc, nil
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"flag"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"git.rpjosh.de/RPJosh/go-logger"
@@ -13,8 +18,9 @@ import (
 var version string
 
 type WebApplication struct {
-	logger *logger.Logger
-	config *models.WebConfig
+	logger    *logger.Logger
+	config    *models.WebConfig
+	scheduler *ncworker.NcConvertScheduler
 }
 
 func main() {
@@ -25,6 +31,13 @@ func main() {
 		logger.Error(err.Error())
 	}
 
+	// Execute a subcommand instead of the scheduler
+	if args := flag.Args(); len(args) > 0 {
+		code := runCommand(config, args)
+		logger.CloseFile()
+		os.Exit(code)
+	}
+
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 		MinVersion:       tls.VersionTLS12,
@@ -38,9 +51,24 @@ func main() {
 		},
 	}
 
+	if !config.Server.OneShot {
+		if err := config.Auth.Validate(); err != nil {
+			logger.Fatal("Invalid authentication configuration:\n%s", err)
+		} else if !config.Auth.IsConfigured() && !config.Auth.Disabled {
+			logger.Warning("No authentication is configured → all requests to the API are rejected")
+		}
+	}
+
+	ncConvertUsers, err := ncworker.LoadUsers(config)
+	if err != nil {
+		logger.Fatal("Unable to load the jobs: %s", err)
+	}
+	scheduler := ncworker.NewScheduler(ncConvertUsers, config)
+
 	webApp := WebApplication{
-		logger: logger.GetGlobalLogger(),
-		config: config,
+		logger:    logger.GetGlobalLogger(),
+		config:    config,
+		scheduler: scheduler,
 	}
 
 	srv := &http.Server{
@@ -53,24 +81,42 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	ncConvertUsers, err := models.ParseConvertUsers(webApp.config.Server.JobFile)
-	if err != nil {
-		logger.Error("Unable to parse the file %s: %s", "dd", err)
-	}
-	ncworker.NewScheduler(ncConvertUsers, config)
+	// The API is only needed while jobs are scheduled
+	if !config.Server.OneShot {
+		go func() {
+			logger.Info("Server started on %s", config.Server.Address)
+			var errw error
+			if config.Server.Certificate == "" {
+				errw = srv.ListenAndServe()
+			} else {
+				errw = srv.ListenAndServeTLS(config.Server.Certificate+"cert.pem", config.Server.Certificate+"key.pem")
+			}
 
-	if 1 == 1 {
-		// Webserver for BookStack hooks currently not implemented
-		return
+			if !errors.Is(errw, http.ErrServerClosed) {
+				logger.Fatal("Failed to run the HTTP Server: %s", errw)
+			}
+		}()
 	}
 
-	logger.Info("Server started on %s", config.Server.Address)
-	var errw error
-	if config.Server.Certificate == "" {
-		errw = srv.ListenAndServe()
-	} else {
-		errw = srv.ListenAndServeTLS(config.Server.Certificate+"cert.pem", config.Server.Certificate+"key.pem")
+	scheduler.Wait()
+
+	// The HTTP server and the jobs are stopped at the same time, so that both finish within the shutdownTimeout
+	deadline := time.Now().Add(time.Duration(config.Server.ShutdownTimeout) * time.Second)
+	var wg sync.WaitGroup
+	if !config.Server.OneShot {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Warning("Failed to stop the HTTP Server: %s", err)
+			}
+		}()
 	}
 
-	logger.Error("Failed to run the HTTP Server: %s", errw)
+	code := scheduler.Shutdown(deadline)
+	wg.Wait()
+	logger.CloseFile()
+	os.Exit(code)
 }
@@ -6,7 +6,6 @@ import (
 	"runtime/debug"
 
 	"git.rpjosh.de/RPJosh/go-logger"
-	"github.com/justinas/nosurf"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -27,7 +26,18 @@ func secureHeaders(next http.Handler) http.Handler {
 
 func (app *WebApplication) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		// The secret of webhooks must not be logged
+		uri := *r.URL
+		if query := uri.Query(); query.Has("secret") {
+			query.Set("secret", "***")
+			uri.RawQuery = query.Encode()
+		}
+		// Scrapes of the metrics and the probes would flood the log
+		if r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			logger.Debug("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, uri.RequestURI())
+		} else {
+			logger.Info("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, uri.RequestURI())
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -53,16 +63,3 @@ func (app *WebApplication) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-// Create a NoSurf middleware function which uses a customized CSRF cookie with
-// the Secure, Path and HttpOnly attributes set.
-func noSurf(next http.Handler) http.Handler {
-	csrfHandler := nosurf.New(next)
-	csrfHandler.SetBaseCookie(http.Cookie{
-		HttpOnly: true,
-		Path:     "/",
-		Secure:   true,
-	})
-
-	return csrfHandler
-}
@@ -4,15 +4,16 @@ import (
 	"net/http"
 
 	"git.rpjosh.de/ncDocConverter/internal/api"
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *WebApplication) routes() http.Handler {
-	api := api.Api{Logger: app.logger, Config: app.config}
+	api := api.Api{Logger: app.logger, Config: app.config, Scheduler: app.scheduler}
 
 	router := chi.NewRouter()
-	router.Use(middleware.RealIP, app.recoverPanic, app.logRequest, secureHeaders)
+	router.Use(middleware.RealIP, metrics.InstrumentHandler, app.recoverPanic, app.logRequest, secureHeaders)
 
 	api.SetupServer(router)
 
@@ -3,17 +3,30 @@ module git.rpjosh.de/ncDocConverter
 go 1.18
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/go-chi/chi/v5 v5.0.8
 	github.com/go-co-op/gocron v1.18.0
-	github.com/justinas/nosurf v1.1.1
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/hjson/hjson-go/v4 v4.0.0
+	github.com/prometheus/client_golang v1.16.0
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.11.0
+	golang.org/x/net v0.12.0
+	golang.org/x/sync v0.2.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	git.rpjosh.de/RPJosh/go-logger v1.2.0 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
-	golang.org/x/sync v0.1.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.10.1 // indirect
 	golang.org/x/sys v0.11.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
 )
 
 // https://zhwt.github.io/yaml-to-go/
@@ -5,13 +5,21 @@ import (
 
 	"git.rpjosh.de/RPJosh/go-logger"
 	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/ncworker"
 )
 
 type Api struct {
-	Logger *logger.Logger
-	Config *models.WebConfig
+	Logger    *logger.Logger
+	Config    *models.WebConfig
+	Scheduler *ncworker.NcConvertScheduler
+
+	oidc *oidcVerifier
 }
 
 func (api *Api) SetupServer(router *chi.Mux) {
+	if api.Config.Auth.OIDC.Issuer != "" {
+		api.oidc = newOIDCVerifier(&api.Config.Auth.OIDC)
+	}
+
 	api.routes(router)
 }
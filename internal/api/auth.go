@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// An authenticated client of the API
+type principal struct {
+	Name string
+	// Method that was used for the authentication (token, basic, oidc)
+	Method string
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// Returns the authenticated client of the request
+func getPrincipal(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	return p
+}
+
+// Authenticates the request with a static token, the basic authentication or a token of the OIDC provider.
+// Unauthenticated requests are rejected
+func (api *Api) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := &api.Config.Auth
+		p := api.getClient(r)
+		if p == nil {
+			if len(auth.Users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="ncDocConverter"`)
+			}
+			if len(auth.Tokens) > 0 || api.oidc != nil {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+			}
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// Returns the client of the request or nil if the request is not authenticated
+func (api *Api) getClient(r *http.Request) *principal {
+	if api.Config.Auth.Disabled {
+		return &principal{Name: "anonymous", Method: "none", Scopes: []string{models.ScopeAdmin}}
+	}
+
+	header := r.Header.Get("Authorization")
+	if token := strings.TrimPrefix(header, "Bearer "); token != header && token != "" {
+		return api.authenticateToken(token)
+	} else if username, password, ok := r.BasicAuth(); ok {
+		return api.authenticateUser(username, password)
+	}
+
+	return nil
+}
+
+// Rejects requests of clients without the scope
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := getPrincipal(r)
+			if p == nil || !models.HasScope(p.Scopes, scope) {
+				writeError(w, http.StatusForbidden, "the scope '"+scope+"' is required")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns the client of the static token or the OIDC token
+func (api *Api) authenticateToken(token string) *principal {
+	hash := sha256.Sum256([]byte(token))
+	expected := make([]byte, 32)
+	for _, t := range api.Config.Auth.Tokens {
+		if n, err := hex.Decode(expected, []byte(t.Hash)); err != nil || n != 32 {
+			continue
+		}
+		if subtle.ConstantTimeCompare(hash[:], expected) == 1 {
+			return &principal{Name: t.Name, Method: "token", Scopes: t.Scopes}
+		}
+	}
+
+	// JSON Web Tokens consist of three parts
+	if api.oidc != nil && strings.Count(token, ".") == 2 {
+		p, err := api.oidc.verify(token)
+		if err != nil {
+			logger.Debug("Invalid OIDC token: %s", err)
+			return nil
+		}
+		return p
+	}
+
+	return nil
+}
+
+// Hash of a random password that is compared for unknown users. The response time
+// would otherwise reveal if a username exists
+const dummyPasswordHash = "$2a$10$H.4OXZVsNIPz1GwSRMMSDOAav8mN5AjSCa/6E4AkkjkgN71XHrvJy"
+
+// Returns the user if the password matches
+func (api *Api) authenticateUser(username string, password string) *principal {
+	for _, user := range api.Config.Auth.Users {
+		if user.Username != username {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil
+		}
+
+		return &principal{Name: user.Username, Method: "basic", Scopes: user.Scopes}
+	}
+
+	bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+	return nil
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// An OpenID Connect provider that publishes a single RSA key
+type testProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	// Number of requests for the keys
+	fetches int32
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %s", err)
+	}
+	provider := &testProvider{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": provider.server.URL, "jwks_uri": provider.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&provider.fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": provider.kid,
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+// Returns a token of the provider that is valid for one hour. The claims overwrite the defaults
+func (p *testProvider) token(t *testing.T, kid string, claims jwt.MapClaims) string {
+	all := jwt.MapClaims{
+		"iss":                p.server.URL,
+		"aud":                "ncd",
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"scope":              "openid read",
+	}
+	for key, value := range claims {
+		all[key] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, all)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatalf("failed to sign the token: %s", err)
+	}
+
+	return signed
+}
+
+// Starts a server with the endpoint /read that requires the scope "read" and
+// the endpoint /trigger that requires the scope "trigger". Both return the name of the client
+func newAuthServer(t *testing.T, auth models.Auth) *httptest.Server {
+	api := &Api{Config: &models.WebConfig{Auth: auth}}
+	if auth.OIDC.Issuer != "" {
+		api.oidc = newOIDCVerifier(&api.Config.Auth.OIDC)
+	}
+
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(api.authenticate)
+		for _, scope := range []string{models.ScopeRead, models.ScopeTrigger} {
+			r.With(requireScope(scope)).Get("/"+scope, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]string{"name": getPrincipal(r).Name, "method": getPrincipal(r).Method})
+			})
+		}
+	})
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// Sends a request to the server and returns the status code and the name of the client
+func doAuthRequest(t *testing.T, srv *httptest.Server, path string, setAuth func(r *http.Request)) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %s", err)
+	}
+	if setAuth != nil {
+		setAuth(req)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	defer res.Body.Close()
+
+	body := map[string]string{}
+	json.NewDecoder(res.Body).Decode(&body)
+	return res.StatusCode, body["name"]
+}
+
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	provider := newTestProvider(t)
+	srv := newAuthServer(t, models.Auth{OIDC: models.OIDC{Issuer: provider.server.URL, Audience: "ncd"}})
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantStatus int
+		wantName   string
+	}{
+		{name: "valid token", token: provider.token(t, provider.kid, nil), path: "/read", wantStatus: http.StatusOK, wantName: "alice"},
+		{name: "scopes as array", token: provider.token(t, provider.kid, jwt.MapClaims{"scope": []string{"trigger"}}),
+			path: "/trigger", wantStatus: http.StatusOK, wantName: "alice"},
+		{name: "subject without username", token: provider.token(t, provider.kid, jwt.MapClaims{"preferred_username": ""}),
+			path: "/read", wantStatus: http.StatusOK, wantName: "1234"},
+		{name: "wrong issuer", token: provider.token(t, provider.kid, jwt.MapClaims{"iss": "https://evil.local"}),
+			path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "wrong audience", token: provider.token(t, provider.kid, jwt.MapClaims{"aud": "other"}),
+			path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "expired token", token: provider.token(t, provider.kid, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+			path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "without expiration", token: provider.token(t, provider.kid, jwt.MapClaims{"exp": nil}),
+			path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "unknown kid", token: provider.token(t, "key-2", nil), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "missing scope", token: provider.token(t, provider.kid, jwt.MapClaims{"scope": "openid"}),
+			path: "/read", wantStatus: http.StatusForbidden},
+		{name: "insufficient scope", token: provider.token(t, provider.kid, nil), path: "/trigger", wantStatus: http.StatusForbidden},
+		{name: "invalid signature", token: provider.token(t, provider.kid, nil) + "x", path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "malformed token", token: "a.b.c", path: "/read", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, name := doAuthRequest(t, srv, tt.path, bearer(tt.token))
+			if status != tt.wantStatus {
+				t.Fatalf("status: got %d, want %d", status, tt.wantStatus)
+			}
+			if name != tt.wantName {
+				t.Errorf("name: got '%s', want '%s'", name, tt.wantName)
+			}
+		})
+	}
+
+	// Unknown keys must not cause a request for every token
+	if fetches := atomic.LoadInt32(&provider.fetches); fetches != 1 {
+		t.Errorf("expected a single request for the keys, got %d", fetches)
+	}
+}
+
+func TestOIDCConcurrentKeyFetch(t *testing.T) {
+	provider := newTestProvider(t)
+	srv := newAuthServer(t, models.Auth{OIDC: models.OIDC{Issuer: provider.server.URL}})
+	token := provider.token(t, provider.kid, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _ := doAuthRequest(t, srv, "/read", bearer(token)); status != http.StatusOK {
+				t.Errorf("status: got %d, want %d", status, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches := atomic.LoadInt32(&provider.fetches); fetches != 1 {
+		t.Errorf("expected a single request for the keys, got %d", fetches)
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	srv := newAuthServer(t, models.Auth{Tokens: []models.AuthToken{
+		{Name: "reader", Hash: hash("read-token"), Scopes: []string{models.ScopeRead}},
+		{Name: "ops", Hash: hash("ops-token"), Scopes: []string{models.ScopeTrigger}},
+		{Name: "broken", Hash: "not hex", Scopes: []string{models.ScopeAdmin}},
+	}})
+
+	tests := []struct {
+		name       string
+		setAuth    func(r *http.Request)
+		path       string
+		wantStatus int
+		wantName   string
+	}{
+		{name: "valid token", setAuth: bearer("read-token"), path: "/read", wantStatus: http.StatusOK, wantName: "reader"},
+		{name: "higher scope", setAuth: bearer("ops-token"), path: "/read", wantStatus: http.StatusOK, wantName: "ops"},
+		{name: "insufficient scope", setAuth: bearer("read-token"), path: "/trigger", wantStatus: http.StatusForbidden},
+		{name: "unknown token", setAuth: bearer("other-token"), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "hash as token", setAuth: bearer(hash("read-token")), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "invalid hash", setAuth: bearer("not hex"), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "empty token", setAuth: bearer(""), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "without authorization", path: "/read", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, name := doAuthRequest(t, srv, tt.path, tt.setAuth)
+			if status != tt.wantStatus {
+				t.Fatalf("status: got %d, want %d", status, tt.wantStatus)
+			}
+			if name != tt.wantName {
+				t.Errorf("name: got '%s', want '%s'", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestBasicAuthentication(t *testing.T) {
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("failed to hash the password: %s", err)
+		}
+		return string(h)
+	}
+	srv := newAuthServer(t, models.Auth{Users: []models.AuthUser{
+		{Username: "viewer", PasswordHash: hash("viewer-password"), Scopes: []string{models.ScopeRead}},
+		{Username: "admin", PasswordHash: hash("admin-password"), Scopes: []string{models.ScopeAdmin}},
+	}})
+	basic := func(username string, password string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth(username, password)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		setAuth    func(r *http.Request)
+		path       string
+		wantStatus int
+		wantName   string
+	}{
+		{name: "valid user", setAuth: basic("viewer", "viewer-password"), path: "/read", wantStatus: http.StatusOK, wantName: "viewer"},
+		{name: "admin", setAuth: basic("admin", "admin-password"), path: "/trigger", wantStatus: http.StatusOK, wantName: "admin"},
+		{name: "insufficient scope", setAuth: basic("viewer", "viewer-password"), path: "/trigger", wantStatus: http.StatusForbidden},
+		{name: "wrong password", setAuth: basic("viewer", "admin-password"), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", setAuth: basic("nobody", "viewer-password"), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "case of the username", setAuth: basic("Viewer", "viewer-password"), path: "/read", wantStatus: http.StatusUnauthorized},
+		{name: "bearer token", setAuth: bearer("viewer-password"), path: "/read", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, name := doAuthRequest(t, srv, tt.path, tt.setAuth)
+			if status != tt.wantStatus {
+				t.Fatalf("status: got %d, want %d", status, tt.wantStatus)
+			}
+			if name != tt.wantName {
+				t.Errorf("name: got '%s', want '%s'", name, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+// Returns if the process and the scheduler are working (liveness)
+func (api *Api) getHealth(w http.ResponseWriter, r *http.Request) {
+	if err := api.Scheduler.Health(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Returns if all services the jobs depend on are available (readiness).
+// The dependencies are only returned to clients with the scope "read", because they contain the URLs and errors
+func (api *Api) getReadiness(w http.ResponseWriter, r *http.Request) {
+	readiness := api.Scheduler.Readiness(r.Context())
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	if p := api.getClient(r); p == nil || !models.HasScope(p.Scopes, models.ScopeRead) {
+		readiness.Dependencies = nil
+	}
+	writeJSON(w, status, readiness)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/ncworker"
+)
+
+// Writes the data as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Warning("Failed to write the response: %s", err)
+	}
+}
+
+// Writes the error message as JSON with the given status code
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Writes the error of the scheduler with a matching status code
+func writeSchedulerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ncworker.ErrJobNotFound), errors.Is(err, ncworker.ErrRunNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ncworker.ErrJobRunning), errors.Is(err, ncworker.ErrJobNotRunning):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ncworker.ErrShuttingDown):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		logger.Error("Unexpected error in the API: %s", err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Returns all scheduled jobs of all users
+func (api *Api) getJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.Scheduler.Jobs())
+}
+
+// Returns a single job with its last run
+func (api *Api) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := api.Scheduler.Job(chi.URLParam(r, "id"))
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Starts the job in the background
+func (api *Api) runJob(w http.ResponseWriter, r *http.Request) {
+	run, err := api.Scheduler.Trigger(chi.URLParam(r, "id"))
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// Starts all jobs that are not running in the background
+func (api *Api) runAllJobs(w http.ResponseWriter, r *http.Request) {
+	runs, err := api.Scheduler.TriggerAll()
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, runs)
+}
+
+// Cancels the running job
+func (api *Api) cancelJob(w http.ResponseWriter, r *http.Request) {
+	run, err := api.Scheduler.Cancel(chi.URLParam(r, "id"))
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// Returns the latest runs. They can be filtered by the job with the query parameter "job"
+func (api *Api) getRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.Scheduler.Runs(r.URL.Query().Get("job")))
+}
+
+// Returns a single run
+func (api *Api) getRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run id")
+		return
+	}
+
+	run, err := api.Scheduler.Run(id)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// Signing algorithms of the tokens that are accepted
+var oidcMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Verifies the tokens of an OpenID Connect provider with its public keys (JWKS)
+type oidcVerifier struct {
+	config *models.OIDC
+	client http.Client
+	// Concurrent requests with an unknown key wait for the same fetch of the keys
+	fetches singleflight.Group
+
+	mu sync.Mutex
+	// Public keys indexed by their ID
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// A JSON Web Key Set
+type jwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		// RSA
+		N string `json:"n"`
+		E string `json:"e"`
+		// EC
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+func newOIDCVerifier(config *models.OIDC) *oidcVerifier {
+	return &oidcVerifier{
+		config: config,
+		client: http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+// Validates the signature, issuer, audience and expiration of the token and returns its client
+func (v *oidcVerifier) verify(token string) (*principal, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(oidcMethods))
+	if _, err := parser.ParseWithClaims(token, claims, v.getKey); err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(v.config.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer")
+	}
+	if v.config.Audience != "" && !claims.VerifyAudience(v.config.Audience, true) {
+		return nil, fmt.Errorf("unexpected audience")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token is expired")
+	}
+
+	name, _ := claims["preferred_username"].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+
+	return &principal{Name: name, Method: "oidc", Scopes: v.getScopes(claims)}, nil
+}
+
+// Returns the scopes of the token. The claim can be a space separated string or an array
+func (v *oidcVerifier) getScopes(claims jwt.MapClaims) []string {
+	claim := v.config.ScopesClaim
+	if claim == "" {
+		claim = "scope"
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		rtc := make([]string, 0, len(value))
+		for _, scope := range value {
+			if s, ok := scope.(string); ok {
+				rtc = append(rtc, s)
+			}
+		}
+		return rtc
+	default:
+		return []string{}
+	}
+}
+
+// Returns the public key the token was signed with.
+// The keys are fetched again if the key is unknown (e.g. after a key rotation)
+func (v *oidcVerifier) getKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	v.mu.Lock()
+	_, exists := v.keys[kid]
+	// Prevent that invalid tokens cause a request for every token
+	refresh := (!exists && time.Since(v.fetchedAt) > time.Minute) || time.Since(v.fetchedAt) > time.Hour
+	v.mu.Unlock()
+
+	// The lock is not held during the request, so that tokens with known keys are still verified
+	if refresh {
+		v.fetches.Do("keys", func() (interface{}, error) {
+			keys, err := v.fetchKeys()
+
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			v.fetchedAt = time.Now()
+			if err != nil {
+				logger.Warning("Failed to fetch the keys of the OIDC provider: %s", err)
+				return nil, err
+			}
+			v.keys = keys
+
+			return nil, nil
+		})
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, exists := v.keys[kid]
+
+	// Tokens without a key ID are accepted if the provider has a single key
+	if !exists && kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, nil
+		}
+	}
+	if !exists {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+
+	return key, nil
+}
+
+// Fetches the public keys of the provider and returns them indexed by their ID
+func (v *oidcVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	url := v.config.JWKSURL
+	if url == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		if err := v.getJSON(strings.TrimSuffix(v.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("failed to get the discovery document: %s", err)
+		}
+		url = discovery.JWKSURI
+	}
+
+	set := jwks{}
+	if err := v.getJSON(url, &set); err != nil {
+		return nil, fmt.Errorf("failed to get the keys: %s", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(k.Kty, k.N, k.E, k.Crv, k.X, k.Y)
+		if err != nil {
+			logger.Warning("Ignoring the key '%s' of the OIDC provider: %s", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (v *oidcVerifier) getJSON(url string, target interface{}) error {
+	res, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("expected status code 200, got %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+// Returns the public key of the JSON Web Key
+func parseJWK(kty, n, e, crv, x, y string) (crypto.PublicKey, error) {
+	switch kty {
+	case "RSA":
+		modulus, err := decodeBigInt(n)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := decodeBigInt(e)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", crv)
+		}
+		px, err := decodeBigInt(x)
+		if err != nil {
+			return nil, err
+		}
+		py, err := decodeBigInt(y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(px, py) {
+			return nil, fmt.Errorf("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: px, Y: py}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -1,7 +1,39 @@
 package api
 
-import "github.com/go-chi/chi/v5"
+import (
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"github.com/go-chi/chi/v5"
+)
 
 func (api *Api) routes(router *chi.Mux) {
+	router.Route("/api/v1", func(r chi.Router) {
+		r.Use(api.authenticate)
 
-}
\ No newline at end of file
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeRead))
+			r.Get("/jobs", api.getJobs)
+			r.Get("/jobs/{id}", api.getJob)
+			r.Get("/runs", api.getRuns)
+			r.Get("/runs/{id}", api.getRun)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(models.ScopeTrigger))
+			r.Post("/jobs/run", api.runAllJobs)
+			r.Post("/jobs/{id}/run", api.runJob)
+			r.Post("/jobs/{id}/cancel", api.cancelJob)
+		})
+	})
+
+	// Webhooks are authenticated by their own secret
+	router.Post("/webhooks/bookstack", api.bookStackWebhook)
+
+	if api.Config.Auth.ProtectMetrics {
+		router.With(api.authenticate, requireScope(models.ScopeRead)).Handle("/metrics", metrics.Handler())
+	} else {
+		router.Handle("/metrics", metrics.Handler())
+	}
+	router.Get("/healthz", api.getHealth)
+	router.Get("/readyz", api.getReadiness)
+}
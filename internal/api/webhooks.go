@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"git.rpjosh.de/ncDocConverter/internal/ncworker"
+)
+
+// Maximum size of a webhook payload
+const maxWebhookSize = 1 << 20
+
+// Receives the webhooks of BookStack. BookStack can't add headers, so the shared secret
+// is expected in the query parameter "secret" (or the header "X-Webhook-Secret")
+func (api *Api) bookStackWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get("X-Webhook-Secret")
+	if secret == "" {
+		secret = r.URL.Query().Get("secret")
+	}
+
+	event := ncworker.BookStackEvent{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookSize)).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	jobs, err := api.Scheduler.HandleBookStackEvent(secret, event)
+	if errors.Is(err, ncworker.ErrInvalidSecret) {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	} else if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string][]string{"jobs": jobs})
+}
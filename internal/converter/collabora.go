@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Converter that uses the convert-to API of Collabora Online (coolwsd).
+// The source file is downloaded via WebDAV and uploaded to coolwsd
+type collabora struct {
+	client *nextcloud.Client
+
+	url     string
+	timeout time.Duration
+}
+
+func NewCollabora(config models.Converter, client *nextcloud.Client) (Converter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("no url of the Collabora server given")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 300
+	}
+
+	return &collabora{
+		client:  client,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		timeout: time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+func (c *collabora) Convert(ctx context.Context, source *nextcloud.NcFile, format models.Format) (io.ReadCloser, int64, error) {
+	content, err := c.client.DownloadFile(ctx, source.Path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download the source file: %s", err)
+	}
+
+	// Stream the source file directly into the multipart body
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		defer content.Close()
+
+		// Collabora creates PDF/A files with the pdf filter and a given PDF version
+		var err error
+		if format == models.PDFA {
+			err = form.WriteField("PDFVer", "PDF/A-2b")
+		}
+
+		var part io.Writer
+		if err == nil {
+			part, err = form.CreateFormFile("data", filepath.Base(source.Path))
+		}
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	client := http.Client{Timeout: c.timeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/cool/convert-to/"+format.Extension(), body)
+	if err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := client.Do(req)
+	if err != nil {
+		body.Close()
+		return nil, 0, fmt.Errorf("failed to access the Collabora server: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("failed to access the Collabora server (#%d). Is convert-to allowed for this host?: %s", res.StatusCode, resBody)
+	}
+
+	return res.Body, res.ContentLength, nil
+}
+
+// Checks if the convert-to API is enabled in the capabilities
+func (c *collabora) Check(ctx context.Context) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/hosting/capabilities", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("expected status code 200, got %d", res.StatusCode)
+	}
+
+	capabilities := struct {
+		ConvertTo struct {
+			Available bool `json:"available"`
+		} `json:"convert-to"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&capabilities); err != nil {
+		return fmt.Errorf("failed to decode response: %s", err)
+	}
+	if !capabilities.ConvertTo.Available {
+		return fmt.Errorf("the convert-to API is disabled")
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// A service that converts office documents stored in nextcloud
+type Converter interface {
+	// Converts the given source file to the format.
+	// The content of the converted file and its size (-1 if unknown) are returned.
+	// The content has to be closed by the caller
+	Convert(ctx context.Context, source *nextcloud.NcFile, format models.Format) (io.ReadCloser, int64, error)
+
+	// Returns an error if the service is not available
+	Check(ctx context.Context) error
+}
+
+// Returns a name of the converter for displaying
+func GetName(config models.Converter, client *nextcloud.Client) string {
+	switch config.Type {
+	case "", models.OnlyOfficeConverter:
+		return "onlyoffice (" + client.BaseURL() + ")"
+	default:
+		return string(config.Type) + " (" + config.URL + ")"
+	}
+}
+
+// Returns the converter for the given configuration. When no type is given,
+// the OnlyOffice app of nextcloud is used
+func New(config models.Converter, client *nextcloud.Client) (Converter, error) {
+	// Only Collabora downloads the documents itself via WebDAV
+	if !client.IsNextcloud() && config.Type != models.CollaboraConverter {
+		return nil, fmt.Errorf("only the converter 'collabora' supports a generic WebDAV server as the source")
+	}
+
+	switch config.Type {
+	case "", models.OnlyOfficeConverter:
+		return NewOnlyOffice(client), nil
+	case models.DocumentServerConverter:
+		return NewDocumentServer(config, client)
+	case models.CollaboraConverter:
+		return NewCollabora(config, client)
+	default:
+		return nil, fmt.Errorf("invalid converter type given: '%s'. Expected 'onlyoffice', 'documentserver' or 'collabora'", config.Type)
+	}
+}
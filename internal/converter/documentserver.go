@@ -0,0 +1,246 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Converter that uses the ConvertService API of the OnlyOffice Document Server.
+// The Document Server downloads the source file itself via a direct link of nextcloud
+type documentServer struct {
+	client *nextcloud.Client
+
+	url     string
+	secret  string
+	timeout time.Duration
+}
+
+type convertRequest struct {
+	Async      bool   `json:"async"`
+	FileType   string `json:"filetype"`
+	Key        string `json:"key"`
+	OutputType string `json:"outputtype"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	Token      string `json:"token,omitempty"`
+}
+
+type convertResponse struct {
+	EndConvert bool   `json:"endConvert"`
+	FileURL    string `json:"fileUrl"`
+	Percent    int    `json:"percent"`
+	Error      int    `json:"error"`
+}
+
+// Error codes returned by the ConvertService
+var documentServerErrors = map[int]string{
+	-1: "unknown error",
+	-2: "conversion timeout",
+	-3: "conversion error",
+	-4: "error while downloading the source file",
+	-5: "incorrect password",
+	-6: "error while accessing the conversion result database",
+	-7: "input error",
+	-8: "invalid token",
+}
+
+func NewDocumentServer(config models.Converter, client *nextcloud.Client) (Converter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("no url of the Document Server given")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 300
+	}
+
+	return &documentServer{
+		client:  client,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		secret:  string(config.Secret),
+		timeout: time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+func (c *documentServer) Convert(ctx context.Context, source *nextcloud.NcFile, format models.Format) (io.ReadCloser, int64, error) {
+	sourceURL, err := c.client.GetDirectDownloadURL(ctx, source.Fileid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get a download link for the Document Server: %s", err)
+	}
+
+	name := filepath.Base(source.Path)
+	request := convertRequest{
+		Async:    true,
+		FileType: strings.TrimPrefix(strings.ToLower(source.Extension), "."),
+		// The document server caches the result by this key → it has to change with the file
+		Key:        fmt.Sprintf("%d_%d_%s", source.Fileid, source.LastModified.Unix(), format),
+		OutputType: string(format),
+		Title:      strings.TrimSuffix(name, filepath.Ext(name)) + "." + format.Extension(),
+		URL:        sourceURL,
+	}
+
+	// Poll the convert service until the conversion finished
+	deadline := time.Now().Add(c.timeout)
+	wait := 500 * time.Millisecond
+	for {
+		res, err := c.sendConvertRequest(ctx, request)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if res.Error != 0 {
+			msg, exists := documentServerErrors[res.Error]
+			if !exists {
+				msg = "unknown error"
+			}
+			return nil, 0, fmt.Errorf("the Document Server failed to convert the file (%d): %s", res.Error, msg)
+		}
+
+		if res.EndConvert {
+			return c.download(ctx, res.FileURL)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, 0, fmt.Errorf("the conversion did not finish within %s (%d%%)", c.timeout, res.Percent)
+		}
+
+		logger.Debug("Conversion of %s in progress (%d%%)", source.Path, res.Percent)
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait < 5*time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// Sends the request to the ConvertService. When a secret is configured
+// the request will be signed
+func (c *documentServer) sendConvertRequest(ctx context.Context, request convertRequest) (*convertResponse, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+
+	var authHeader string
+	if c.secret != "" {
+		// Depending on the configuration, the token is either expected in the header or in the body
+		header, err := signJWT(map[string]interface{}{"payload": request}, c.secret)
+		if err != nil {
+			return nil, err
+		}
+		authHeader = "Bearer " + header
+
+		token, err := signJWT(request, c.secret)
+		if err != nil {
+			return nil, err
+		}
+		request.Token = token
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/ConvertService.ashx", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access the Document Server: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("failed to access the Document Server (#%d): %s", res.StatusCode, resBody)
+	}
+
+	rtc := convertResponse{}
+	if err = json.NewDecoder(res.Body).Decode(&rtc); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	return &rtc, nil
+}
+
+// Downloads the converted file from the Document Server
+func (c *documentServer) download(ctx context.Context, fileURL string) (io.ReadCloser, int64, error) {
+	client := http.Client{Timeout: 5 * time.Minute}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download the converted file: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("failed to download the converted file: expected status code 200, got %d", res.StatusCode)
+	}
+
+	return res.Body, res.ContentLength, nil
+}
+
+// Returns a JSON Web Token with the given claims signed by the secret (HS256)
+func signJWT(claims interface{}, secret string) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Checks the health of the Document Server
+func (c *documentServer) Check(ctx context.Context) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/healthcheck", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode != 200 || strings.TrimSpace(string(body)) != "true" {
+		return fmt.Errorf("the Document Server is not healthy (#%d): %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Converter that uses the OnlyOffice app of nextcloud
+type onlyOffice struct {
+	client *nextcloud.Client
+}
+
+func NewOnlyOffice(client *nextcloud.Client) Converter {
+	return &onlyOffice{client: client}
+}
+
+func (c *onlyOffice) Convert(ctx context.Context, source *nextcloud.NcFile, format models.Format) (io.ReadCloser, int64, error) {
+	q := url.Values{}
+	q.Add("fileId", fmt.Sprint(source.Fileid))
+	q.Add("toExtension", string(format))
+
+	res, err := c.client.Get(ctx, "apps/onlyoffice/downloadas", q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to access the convert api: %s", err)
+	}
+
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("failed to access the convert api (#%d). Do you have OnlyOffice installed?: %s", res.StatusCode, body)
+	}
+
+	return res.Body, res.ContentLength, nil
+}
+
+func (c *onlyOffice) Check(ctx context.Context) error {
+	res, err := c.client.Get(ctx, "apps/onlyoffice/downloadas", url.Values{})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// Without a file a client error is returned. Nextcloud responds with 404 if the app is not installed
+	if res.StatusCode == 404 || res.StatusCode >= 500 {
+		return fmt.Errorf("the OnlyOffice app is not available (#%d)", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+// An app password of a nextcloud user obtained by the login flow
+type Credential struct {
+	// Base URL of nextcloud as given in the job file
+	Server      string    `json:"server"`
+	LoginName   string    `json:"loginName"`
+	AppPassword string    `json:"appPassword"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Persistent store of app passwords.
+// The credentials are saved as a JSON file inside the data directory that is only readable by the owner
+type Store struct {
+	path        string
+	credentials []Credential
+}
+
+// Opens the credentials file inside the given data directory.
+// If the file does not exist, an empty store is returned
+func Open(dataDir string) (*Store, error) {
+	store := &Store{
+		path: filepath.Join(dataDir, "credentials.json"),
+	}
+
+	content, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the credentials file '%s': %s", store.path, err)
+	}
+
+	if err := json.Unmarshal(content, &store.credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse the credentials file '%s': %s", store.path, err)
+	}
+
+	return store, nil
+}
+
+// Returns the path of the credentials file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Returns the credential of the user for the nextcloud server
+func (s *Store) Get(server string, loginName string) (Credential, bool) {
+	for _, credential := range s.credentials {
+		if matches(credential, server, loginName) {
+			return credential, true
+		}
+	}
+
+	return Credential{}, false
+}
+
+// Returns all saved credentials
+func (s *Store) List() []Credential {
+	return append([]Credential{}, s.credentials...)
+}
+
+// Adds or replaces the credential of the user
+func (s *Store) Set(credential Credential) {
+	credential.Server = normalizeServer(credential.Server)
+	for i, existing := range s.credentials {
+		if matches(existing, credential.Server, credential.LoginName) {
+			s.credentials[i] = credential
+			return
+		}
+	}
+
+	s.credentials = append(s.credentials, credential)
+}
+
+// Removes the credential of the user
+func (s *Store) Delete(server string, loginName string) {
+	for i, credential := range s.credentials {
+		if matches(credential, server, loginName) {
+			s.credentials = append(s.credentials[:i], s.credentials[i+1:]...)
+			return
+		}
+	}
+}
+
+// Writes the credentials to the disk
+func (s *Store) Save() error {
+	content, err := json.MarshalIndent(s.credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create the data directory: %s", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("failed to write the credentials file: %s", err)
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Sets the app password for all users of the job file without a password
+func (s *Store) Apply(users *models.NcConvertUsers) {
+	for i, user := range users.Users {
+		if user.Password != "" {
+			continue
+		}
+
+		if credential, exists := s.Get(user.NextcloudBaseUrl, user.Username); exists {
+			users.Users[i].Password = models.Secret(credential.AppPassword)
+		}
+	}
+}
+
+// Returns true if the credential belongs to the user of the server
+func matches(credential Credential, server string, loginName string) bool {
+	return credential.Server == normalizeServer(server) && strings.EqualFold(credential.LoginName, loginName)
+}
+
+func normalizeServer(server string) string {
+	return strings.TrimSuffix(strings.TrimSpace(server), "/")
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ncdocconverter"
+
+// Labels of the metrics that belong to a job
+var jobLabels = []string{"type", "user", "job"}
+
+var (
+	DocumentsConverted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "documents_converted_total",
+		Help:      "Count of the converted documents and books",
+	}, append(jobLabels, "format"))
+
+	DocumentsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "documents_failed_total",
+		Help:      "Count of the documents and books that could not be converted, moved or deleted",
+	}, append(jobLabels, "format"))
+
+	DocumentsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "documents_deleted_total",
+		Help:      "Count of the converted files that were deleted or moved to the archive because their source does not exist anymore",
+	}, jobLabels)
+
+	UploadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Size of the uploaded converted files in bytes",
+	}, jobLabels)
+
+	BookStackCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bookstack_cache_total",
+		Help:      "Executions of BookStack jobs that used the cached shelves and books (hit) or fetched them again (miss)",
+	}, []string{"user", "job", "result"})
+
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of the requests to the APIs of Nextcloud and BookStack",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "method", "code"})
+
+	JobRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_run_duration_seconds",
+		Help:      "Duration of the job executions by their status",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, append(jobLabels, "status"))
+
+	JobLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Unix time of the last execution of the job that finished without errors",
+	}, jobLabels)
+
+	JobsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_running",
+		Help:      "Count of the jobs that are currently executed",
+	})
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests to the HTTP server of the program",
+	}, []string{"method", "code"})
+)
+
+// Returns the handler that serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Records the duration and status code of all requests to the given API
+func InstrumentTransport(api string, next http.RoundTripper) http.RoundTripper {
+	return promhttp.InstrumentRoundTripperDuration(APIRequestDuration.MustCurryWith(prometheus.Labels{"api": api}), next)
+}
+
+// Counts the requests to the HTTP server
+func InstrumentHandler(next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(HTTPRequests, next)
+}
+
+// Counts the bytes that were read
+type CountingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func NewCountingReader(reader io.Reader) *CountingReader {
+	return &CountingReader{reader: reader}
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	atomic.AddInt64(&r.count, int64(n))
+	return n, err
+}
+
+// Returns the count of the bytes that were read
+func (r *CountingReader) Count() int64 {
+	return atomic.LoadInt64(&r.count)
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Permissions of the API. Every scope includes the permissions of the lower scopes
+const (
+	// View the jobs and runs
+	ScopeRead = "read"
+	// Start and cancel jobs
+	ScopeTrigger = "trigger"
+	// All permissions
+	ScopeAdmin = "admin"
+)
+
+// Scopes ordered by their permissions
+var Scopes = []string{ScopeRead, ScopeTrigger, ScopeAdmin}
+
+// Authentication of the HTTP API. Without any method, all requests are rejected
+type Auth struct {
+	// Allows all requests without any authentication (e.g. behind an authenticating proxy)
+	Disabled bool `yaml:"disabled"`
+
+	Tokens []AuthToken `yaml:"tokens"`
+	Users  []AuthUser  `yaml:"users"`
+	OIDC   OIDC        `yaml:"oidc"`
+	// Requires the scope "read" for the metrics (/metrics)
+	ProtectMetrics bool `yaml:"protectMetrics"`
+}
+
+// A static bearer token
+type AuthToken struct {
+	Name string `yaml:"name"`
+	// Hex encoded SHA-256 hash of the token
+	Hash   string   `yaml:"hash"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// A user for the HTTP basic authentication
+type AuthUser struct {
+	Username string `yaml:"username"`
+	// Bcrypt hash of the password
+	PasswordHash string   `yaml:"passwordHash"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Bearer tokens (JWT) of an OpenID Connect provider
+type OIDC struct {
+	Issuer string `yaml:"issuer"`
+	// Expected audience of the tokens (e.g. the client ID). Not checked if empty
+	Audience string `yaml:"audience"`
+	// URL of the JSON Web Key Set. Defaults to the "jwks_uri" of the discovery document of the issuer
+	JWKSURL string `yaml:"jwksUrl"`
+	// Claim with the scopes of the token (space separated string or array). Defaults to "scope"
+	ScopesClaim string `yaml:"scopesClaim"`
+}
+
+// Returns true if any authentication method is configured
+func (auth *Auth) IsConfigured() bool {
+	return len(auth.Tokens) > 0 || len(auth.Users) > 0 || auth.OIDC.Issuer != ""
+}
+
+// Checks the configured tokens, users and scopes
+func (auth *Auth) Validate() error {
+	errors := []string{}
+	for i, token := range auth.Tokens {
+		if hash, err := hex.DecodeString(token.Hash); err != nil || len(hash) != 32 {
+			errors = append(errors, fmt.Sprintf("auth.tokens[%d].hash: expected a hex encoded SHA-256 hash", i))
+		}
+		errors = append(errors, validateScopes(fmt.Sprintf("auth.tokens[%d].scopes", i), token.Scopes)...)
+	}
+
+	for i, user := range auth.Users {
+		if user.Username == "" {
+			errors = append(errors, fmt.Sprintf("auth.users[%d].username: no username given", i))
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			errors = append(errors, fmt.Sprintf("auth.users[%d].passwordHash: expected a bcrypt hash: %s", i, err))
+		}
+		errors = append(errors, validateScopes(fmt.Sprintf("auth.users[%d].scopes", i), user.Scopes)...)
+	}
+
+	if auth.OIDC.JWKSURL != "" && auth.OIDC.Issuer == "" {
+		errors = append(errors, "auth.oidc.issuer: no issuer given")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+func validateScopes(path string, scopes []string) []string {
+	rtc := []string{}
+	if len(scopes) == 0 {
+		rtc = append(rtc, fmt.Sprintf("%s: no scopes given", path))
+	}
+	for _, scope := range scopes {
+		if GetScopeLevel(scope) < 0 {
+			rtc = append(rtc, fmt.Sprintf("%s: unknown scope '%s' (expected %s)", path, scope, strings.Join(Scopes, ", ")))
+		}
+	}
+
+	return rtc
+}
+
+// Returns the index of the scope in Scopes or -1 if it's unknown
+func GetScopeLevel(scope string) int {
+	for i, s := range Scopes {
+		if s == scope {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns true if the given scopes include the permissions of the required scope
+func HasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if GetScopeLevel(scope) >= GetScopeLevel(required) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,7 +4,10 @@ package models
 type BookStack struct {
 	URL      string `json:"url"`
 	Username string `json:"username"`
-	Token    string `json:"apiToken"`
+	Token    Secret `json:"apiToken"`
+
+	// Shared secret of the webhooks that trigger the jobs on changes
+	WebhookSecret Secret `json:"webhookSecret"`
 
 	Jobs []BookStackJob `json:"jobs"`
 }
@@ -28,11 +31,13 @@ type BookStackJob struct {
 	Execution string `json:"execution"`
 
 	CacheCount int `json:"cache"`
-}
 
-type Format string
+	// Backend to save the converted files in (defaults to the nextcloud of the user)
+	Destination Storage `json:"destination"`
 
-const (
-	HTML Format = "html"
-	PDF  Format = "pdf"
-)
+	// How converted files without a source are handled (defaults to delete)
+	Deletion Deletion `json:"deletion"`
+
+	// Only print the planned changes without modifying any files
+	DryRun bool `json:"dryRun"`
+}
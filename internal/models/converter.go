@@ -0,0 +1,28 @@
+package models
+
+// Type of the service that converts office documents
+type ConverterType string
+
+const (
+	// The OnlyOffice app of nextcloud (/apps/onlyoffice/downloadas)
+	OnlyOfficeConverter ConverterType = "onlyoffice"
+	// The ConvertService API of the OnlyOffice Document Server
+	DocumentServerConverter ConverterType = "documentserver"
+	// The convert-to API of Collabora Online (coolwsd)
+	CollaboraConverter ConverterType = "collabora"
+)
+
+// Service that is used to convert the office documents
+type Converter struct {
+	// Type of the converter. Defaults to "onlyoffice"
+	Type ConverterType `json:"type"`
+
+	// Base URL of the Document Server or Collabora (https://office.myDomain.de)
+	URL string `json:"url"`
+	// Secret to sign the requests to the Document Server with (JWT)
+	Secret Secret `json:"secret"`
+
+	// Maximum time in seconds to wait for a conversion to finish.
+	// Defaults to 300 seconds
+	Timeout int `json:"timeout"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Defines what happens with converted files whose source does not exist anymore
+type DeletionPolicy string
+
+const (
+	// The files are deleted so that the destination mirrors the source
+	MirrorDeletion DeletionPolicy = "mirror"
+	// The files are never deleted
+	KeepDeletion DeletionPolicy = "keep"
+	// The files are moved to an archive folder
+	TrashDeletion DeletionPolicy = "trash"
+)
+
+// Handling of files inside the destination directory without a source
+type Deletion struct {
+	// Defaults to "mirror"
+	Policy DeletionPolicy `json:"policy"`
+
+	// Folder to move the files to with the policy "trash".
+	// The folder is relative to the root of the destination and must not be
+	// located inside the destination directory
+	ArchiveDir string `json:"archiveDir"`
+
+	// The deletion is skipped if more than the given percentage of the files inside
+	// the destination directory would be removed (0 = disabled)
+	MaxPercent int `json:"maxPercent"`
+}
+
+// Checks if the configuration is valid for the given destination directory
+func (d *Deletion) Validate(destinationDir string) error {
+	switch d.Policy {
+	case "", MirrorDeletion, KeepDeletion:
+	case TrashDeletion:
+		if d.ArchiveDir == "" {
+			return fmt.Errorf("no archive directory given for the deletion policy 'trash'")
+		}
+		destination := strings.Trim(destinationDir, "/")
+		if destination == "" || strings.HasPrefix(strings.Trim(d.ArchiveDir, "/")+"/", destination+"/") {
+			return fmt.Errorf("the archive directory '%s' must not be located inside the destination directory", d.ArchiveDir)
+		}
+	default:
+		return fmt.Errorf("invalid deletion policy given: '%s'. Expected 'mirror', 'keep' or 'trash'", d.Policy)
+	}
+
+	if d.MaxPercent < 0 || d.MaxPercent > 100 {
+		return fmt.Errorf("the maximum percentage of files to delete has to be between 0 and 100")
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package models
+
+import "strings"
+
+// Output format of the converted documents
+type Format string
+
+const (
+	HTML Format = "html"
+	PDF  Format = "pdf"
+	// PDF/A for long-term archiving
+	PDFA Format = "pdfa"
+	EPUB Format = "epub"
+	DOCX Format = "docx"
+	ODT  Format = "odt"
+	TXT  Format = "txt"
+)
+
+// All formats office documents can be converted to
+var OfficeFormats = []Format{PDF, PDFA, EPUB, DOCX, ODT, HTML, TXT}
+
+// Formats spreadsheets and presentations can be converted to.
+// Text documents can be converted to all office formats
+var sheetFormats = []Format{PDF, PDFA}
+
+// File extensions of the text documents
+var textDocuments = map[string]bool{"doc": true, "docx": true, "docm": true, "dotx": true, "odt": true, "rtf": true}
+
+// Returns the format in lowercase. If no format is given, PDF is returned
+func (f Format) Normalize() Format {
+	if f == "" {
+		return PDF
+	}
+
+	return Format(strings.ToLower(string(f)))
+}
+
+// Returns the file extension (without a dot) of documents in this format
+func (f Format) Extension() string {
+	if f == PDFA {
+		return "pdf"
+	}
+
+	return string(f)
+}
+
+// Returns the content type of documents in this format
+func (f Format) ContentType() string {
+	switch f {
+	case PDF, PDFA:
+		return "application/pdf"
+	case EPUB:
+		return "application/epub+zip"
+	case DOCX:
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case ODT:
+		return "application/vnd.oasis.opendocument.text"
+	case HTML:
+		return "text/html"
+	case TXT:
+		return "text/plain"
+	default:
+		return ""
+	}
+}
+
+// Returns true if office documents can be converted to this format
+func (f Format) IsOfficeFormat() bool {
+	for _, format := range OfficeFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if documents with the given file extension (docx, xlsx) can be converted to this format
+func (f Format) SupportsSource(extension string) bool {
+	if textDocuments[strings.TrimPrefix(strings.ToLower(extension), ".")] {
+		return f.IsOfficeFormat()
+	}
+
+	for _, format := range sheetFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
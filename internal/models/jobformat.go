@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hjson/hjson-go/v4"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Format of the job file
+type JobFileFormat string
+
+const (
+	JSONJobFile  JobFileFormat = "json"
+	YAMLJobFile  JobFileFormat = "yaml"
+	HJSONJobFile JobFileFormat = "hjson"
+)
+
+// Returns the format of the job file determined by its extension.
+// Files with an unknown extension are parsed as JSON
+func GetJobFileFormat(filePath string) JobFileFormat {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return YAMLJobFile
+	case ".hjson", ".json5":
+		// HJSON is a superset of the commonly used features of JSON5 (comments, trailing commas and unquoted keys)
+		return HJSONJobFile
+	default:
+		return JSONJobFile
+	}
+}
+
+// Converts the YAML document to JSON. The line numbers of all values indexed by
+// their JSON path are returned additionally
+func yamlToJSON(content []byte) ([]byte, map[string]int, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, err
+	}
+
+	lines := make(map[string]int)
+	value, err := getYAMLValue(&document, "", lines)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rtc, err := json.Marshal(value)
+	return rtc, lines, err
+}
+
+// Returns the value of the YAML node as it would be decoded from JSON
+func getYAMLValue(node *yaml.Node, path string, lines map[string]int) (interface{}, error) {
+	if _, exists := lines[path]; !exists {
+		lines[path] = node.Line
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return getYAMLValue(node.Content[0], path, lines)
+	case yaml.AliasNode:
+		return getYAMLValue(node.Alias, path, lines)
+	case yaml.MappingNode:
+		rtc := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			child := joinPath(path, key)
+			lines[child] = node.Content[i].Line
+
+			value, err := getYAMLValue(node.Content[i+1], child, lines)
+			if err != nil {
+				return nil, err
+			}
+			rtc[key] = value
+		}
+		return rtc, nil
+	case yaml.SequenceNode:
+		rtc := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			value, err := getYAMLValue(item, fmt.Sprintf("%s[%d]", path, i), lines)
+			if err != nil {
+				return nil, err
+			}
+			rtc[i] = value
+		}
+		return rtc, nil
+	default:
+		var rtc interface{}
+		if err := node.Decode(&rtc); err != nil {
+			return nil, fmt.Errorf("line %d: %s", node.Line, err)
+		}
+		return rtc, nil
+	}
+}
+
+// Converts the HJSON (or JSON5) document to JSON
+func hjsonToJSON(content []byte) ([]byte, error) {
+	var value interface{}
+	if err := hjson.Unmarshal(content, &value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
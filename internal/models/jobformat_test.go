@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes the content into a job file with the given name and returns its path
+func writeTestJobFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write the job file: %s", err)
+	}
+
+	return path
+}
+
+func TestParseJobFileFormats(t *testing.T) {
+	files := map[string]string{
+		"jobs.json": `{
+			"nextcloudUsers": [{
+				"nextcloudUrl": "https://cloud.local", "username": "user", "password": "secret",
+				"jobs": [{"jobName": "office", "sourceDir": "docs/", "destinationDir": "pdf/", "keepFolders": true, "execution": "0 1 * * *"}]
+			}]
+		}`,
+		"jobs.hjson": `{
+			// Comments, unquoted keys and strings and trailing commas are allowed
+			nextcloudUsers: [{
+				nextcloudUrl: https://cloud.local
+				username: user
+				password: secret
+				jobs: [{jobName: "office", sourceDir: "docs/", destinationDir: "pdf/", keepFolders: true, execution: "0 1 * * *",}]
+			}]
+		}`,
+		"jobs.json5": `{
+			nextcloudUsers: [{
+				nextcloudUrl: "https://cloud.local", username: "user", password: "secret",
+				jobs: [{jobName: "office", sourceDir: "docs/", destinationDir: "pdf/", keepFolders: true, execution: "0 1 * * *"}],
+			}],
+		}`,
+		"jobs.yml": `
+nextcloudUsers:
+  - nextcloudUrl: https://cloud.local
+    username: user
+    password: secret
+    jobs:
+      - jobName: office
+        sourceDir: docs/
+        destinationDir: pdf/
+        keepFolders: true
+        execution: "0 1 * * *"
+`,
+	}
+
+	want := []NcConvertJob{{JobName: "office", SourceDir: "docs/", DestinationDir: "pdf/", KeepFolders: true, Recursive: true, Execution: "0 1 * * *"}}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			users, err := ParseConvertUsers(writeTestJobFile(t, name, content))
+			if err != nil {
+				t.Fatalf("failed to parse the job file: %s", err)
+			}
+			if err := users.Validate(true); err != nil {
+				t.Fatalf("the job file is invalid: %s", err)
+			}
+
+			if len(users.Users) != 1 {
+				t.Fatalf("expected one user, got %d", len(users.Users))
+			}
+			user := users.Users[0]
+			if user.NextcloudBaseUrl != "https://cloud.local" || user.Username != "user" || user.Password != "secret" {
+				t.Errorf("unexpected user: %+v", user)
+			}
+			if !reflect.DeepEqual(user.ConvertJobs, want) {
+				t.Errorf("got %+v, want %+v", user.ConvertJobs, want)
+			}
+		})
+	}
+}
+
+func TestJobFileErrorLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		// Expected line and part of the message of the first error
+		wantLine    int
+		wantMessage string
+	}{
+		{
+			name: "json syntax error",
+			file: "jobs.json",
+			content: `{
+	"nextcloudUsers": [
+		{"username": "user",}
+	]
+}`,
+			wantLine:    3,
+			wantMessage: "invalid character",
+		},
+		{
+			name: "json unknown field",
+			file: "jobs.json",
+			content: `{"nextcloudUsers": [{
+	"nextcloudUrl": "https://cloud.local", "username": "user", "password": "secret",
+	"jobs": [{
+		"jobName": "office", "destinationDir": "pdf/",
+		"unknown": true
+	}]
+}]}`,
+			wantLine:    5,
+			wantMessage: "unknown",
+		},
+		{
+			name: "yaml syntax error",
+			file: "jobs.yaml",
+			content: `nextcloudUsers:
+  - username: user
+    password: secret: value
+`,
+			wantLine:    3,
+			wantMessage: "yaml",
+		},
+		{
+			name: "yaml wrong type",
+			file: "jobs.yaml",
+			content: `nextcloudUsers:
+  - nextcloudUrl: https://cloud.local
+    username: user
+    password: secret
+    jobs:
+      - jobName: office
+        destinationDir: pdf/
+        recursive: [true]
+`,
+			wantLine:    8,
+			wantMessage: "recursive",
+		},
+		{
+			name: "yaml invalid cron expression",
+			file: "jobs.yml",
+			content: `nextcloudUsers:
+  - nextcloudUrl: https://cloud.local
+    username: user
+    password: secret
+
+    jobs:
+      - jobName: office
+        destinationDir: pdf/
+        execution: every day
+`,
+			wantLine:    9,
+			wantMessage: "execution",
+		},
+		{
+			name: "yaml missing value",
+			file: "jobs.yml",
+			content: `nextcloudUsers:
+  - nextcloudUrl: https://cloud.local
+    username: user
+    jobs: []
+`,
+			wantLine:    2,
+			wantMessage: "password",
+		},
+		{
+			name: "hjson without line numbers",
+			file: "jobs.hjson",
+			content: `{
+  nextcloudUsers: [{
+    nextcloudUrl: https://cloud.local
+    username: user
+  }]
+}`,
+			wantMessage: "password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := ParseConvertUsers(writeTestJobFile(t, tt.file, tt.content))
+			if err == nil {
+				err = users.Validate(true)
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			var validationErrors ValidationErrors
+			if !errors.As(err, &validationErrors) {
+				// Syntax errors of YAML contain the line in the message
+				if !strings.Contains(err.Error(), fmt.Sprintf("line %d", tt.wantLine)) ||
+					!strings.Contains(err.Error(), tt.wantMessage) {
+					t.Errorf("expected an error of line %d containing '%s', got: %s", tt.wantLine, tt.wantMessage, err)
+				}
+				return
+			}
+
+			first := validationErrors[0]
+			if first.Line != tt.wantLine || !strings.Contains(first.Error(), tt.wantMessage) {
+				t.Errorf("expected an error of line %d containing '%s', got: %s", tt.wantLine, tt.wantMessage, err)
+			}
+		})
+	}
+}
+
+func TestExampleJobFiles(t *testing.T) {
+	for _, name := range []string{"ncConverter.json", "ncConverter.hjson"} {
+		t.Run(name, func(t *testing.T) {
+			users, err := ParseConvertUsers(filepath.Join("..", "..", "configs", name))
+			if err != nil {
+				t.Fatalf("failed to parse the example: %s", err)
+			}
+			if err := users.Validate(false); err != nil {
+				t.Errorf("the example is invalid: %s", err)
+			}
+		})
+	}
+}
@@ -1,10 +1,11 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strconv"
 )
 
 // The root nextcloud user where the files are stored
@@ -12,46 +13,186 @@ import (
 type NextcloudUser struct {
 	NextcloudBaseUrl string `json:"nextcloudUrl"`
 	Username         string `json:"username"`
-	Password         string `json:"password"`
+	Password         Secret `json:"password"`
+	// Options of the HTTP client to access nextcloud
+	Client NextcloudClient `json:"client"`
 
 	// OnlyOffice
 	ConvertJobs []NcConvertJob `json:"jobs"`
+	// Default service to convert the documents of all jobs with
+	Converter Converter `json:"converter"`
 
 	// BookStack
 	BookStack BookStack `json:"bookStack"`
 }
 
+// Options of the HTTP client to access nextcloud
+type NextcloudClient struct {
+	// Timeout in seconds of a single request (default 30)
+	Timeout int `json:"timeout"`
+	// Timeout in seconds for uploading and downloading files (default 600)
+	TransferTimeout int `json:"transferTimeout"`
+	// Count of retries for temporary errors like 423, 429 or 5xx (default 3, -1 to disable)
+	Retries int `json:"retries"`
+
+	// Files larger than this size in MiB are uploaded in chunks (default 20, -1 to disable)
+	ChunkThreshold int `json:"chunkThreshold"`
+	// Size of a single chunk in MiB (default 10, minimum 5)
+	ChunkSize int `json:"chunkSize"`
+
+	// How the files of a directory are listed (default "auto")
+	Listing ListingStrategy `json:"listing"`
+}
+
+// Method to list the files of a directory in nextcloud
+type ListingStrategy string
+
+const (
+	// WebDAV SEARCH is used. If the server does not support it, PROPFIND is used instead
+	AutoListing ListingStrategy = "auto"
+	// The WebDAV SEARCH extension of nextcloud is used
+	SearchListing ListingStrategy = "search"
+	// All directories are walked with PROPFIND requests (supported by every WebDAV server)
+	PropfindListing ListingStrategy = "propfind"
+)
+
 // A OnlyOffice docs convert job
 type NcConvertJob struct {
 	JobName        string `json:"jobName"`
 	SourceDir      string `json:"sourceDir"`
 	DestinationDir string `json:"destinationDir"`
-	KeepFolders    string `json:"keepFolders"`
-	Recursive      string `json:"recursive"`
-	Execution      string `json:"execution"`
+	// If the folder structure of the source should be kept.
+	// Otherwise all files are saved directly in the destination dir
+	KeepFolders StringBool `json:"keepFolders"`
+	// If the source dir should be searched recursively
+	Recursive StringBool `json:"recursive"`
+	Execution string     `json:"execution"`
+
+	// File extensions of the documents to convert ("docx", "odt").
+	// Defaults to all supported office formats
+	SourceTypes []string `json:"sourceTypes"`
+	// Format to convert the documents to. Defaults to "pdf"
+	Format Format `json:"format"`
+
+	// Backend to read the documents from (defaults to the nextcloud of the user)
+	Source Storage `json:"source"`
+	// Backend to save the converted files in (defaults to the nextcloud of the user)
+	Destination Storage `json:"destination"`
+	// Service to convert the documents with (defaults to the converter of the user)
+	Converter Converter `json:"converter"`
+
+	// How converted files without a source are handled (defaults to delete)
+	Deletion Deletion `json:"deletion"`
+
+	// Only print the planned changes without modifying any files
+	DryRun bool `json:"dryRun"`
+}
+
+// The content types of all supported office documents indexed by the file extension
+var OfficeContentTypes = map[string][]string{
+	"doc":  {"application/msword"},
+	"docx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	"docm": {"application/vnd.ms-word.document.macroEnabled.12"},
+	"dotx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.template"},
+	"odt":  {"application/vnd.oasis.opendocument.text"},
+	"rtf":  {"text/rtf", "application/rtf"},
+	"xls":  {"application/vnd.ms-excel"},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	"xlsm": {"application/vnd.ms-excel.sheet.macroEnabled.12"},
+	"ods":  {"application/vnd.oasis.opendocument.spreadsheet"},
+	"ppt":  {"application/vnd.ms-powerpoint"},
+	"pptx": {"application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	"ppsx": {"application/vnd.openxmlformats-officedocument.presentationml.slideshow"},
+	"odp":  {"application/vnd.oasis.opendocument.presentation"},
+}
+
+// Parses the job and applies the default values for fields that are not given
+func (job *NcConvertJob) UnmarshalJSON(data []byte) error {
+	// Use an alias to not call this function recursively
+	type ncConvertJob NcConvertJob
+	rtc := ncConvertJob{
+		KeepFolders: true,
+		Recursive:   true,
+	}
+
+	if err := json.Unmarshal(data, &rtc); err != nil {
+		return err
+	}
+	*job = NcConvertJob(rtc)
+
+	return nil
+}
+
+// A boolean that also accepts the string values "true" and "false" in the job file
+type StringBool bool
+
+func (b *StringBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		// Keep the default value
+	case bool:
+		*b = StringBool(v)
+	case string:
+		if v == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean value '%s'", v)
+		}
+		*b = StringBool(parsed)
+	default:
+		return fmt.Errorf("invalid boolean value %s", data)
+	}
+
+	return nil
 }
 
 type NcConvertUsers struct {
-	Users []NextcloudUser `json:"nextcloudUsers"`
+	// Reference to the JSON schema of the job file for editors
+	Schema string          `json:"$schema"`
+	Users  []NextcloudUser `json:"nextcloudUsers"`
+
+	// Line numbers of the values in the job file indexed by their JSON path
+	lines map[string]int
+	// Unknown fields and values of the wrong type that were found while parsing
+	errors ValidationErrors
 }
 
-// Parses the given file to the in memory struct
+// Reads and parses the given job file (JSON, YAML or HJSON determined by the extension).
+// Only syntax errors are returned. Unknown fields and invalid values are reported with their
+// path and line number by Validate
 func ParseConvertUsers(filePath string) (*NcConvertUsers, error) {
-
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE, 0644)
+	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open the file '%s': %s", filePath, err)
+		return nil, fmt.Errorf("failed to read the job file '%s': %s", filePath, err)
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("the job file '%s' is empty", filePath)
 	}
-	defer file.Close()
 
-	byteValue, err := ioutil.ReadAll(file)
+	var lines map[string]int
+	switch GetJobFileFormat(filePath) {
+	case YAMLJobFile:
+		content, lines, err = yamlToJSON(content)
+	case HJSONJobFile:
+		// The HJSON parser provides no line numbers
+		content, err = hjsonToJSON(content)
+		lines = make(map[string]int)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse 'ncConverter.json': %s", err)
+		return nil, fmt.Errorf("failed to parse the job file '%s': %s", filePath, err)
 	}
 
-	var conv NcConvertUsers
-
-	json.Unmarshal(byteValue, &conv)
+	conv, err := parseConvertUsers(content, lines)
+	if err != nil {
+		return nil, fmt.Errorf("the job file '%s' is invalid:\n%w", filePath, err)
+	}
 
-	return &conv, nil
+	return conv, nil
 }
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Allowed values of the enumerations in the job file
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(ConverterType("")):   {"onlyoffice", "documentserver", "collabora"},
+	reflect.TypeOf(StorageType("")):     {"nextcloud", "local", "webdav"},
+	reflect.TypeOf(DeletionPolicy("")):  {"mirror", "keep", "trash"},
+	reflect.TypeOf(ListingStrategy("")): {"auto", "search", "propfind"},
+}
+
+// Allowed formats of the jobs indexed by the type of the job. The formats are case-insensitive
+var schemaFormats = map[reflect.Type][]Format{
+	reflect.TypeOf(NcConvertJob{}): OfficeFormats,
+	reflect.TypeOf(BookStackJob{}): {HTML, PDF},
+}
+
+// Fields that have to be given in the job file indexed by the type
+var schemaRequired = map[reflect.Type][]string{
+	reflect.TypeOf(NcConvertUsers{}): {"nextcloudUsers"},
+	reflect.TypeOf(NextcloudUser{}):  {"nextcloudUrl", "username"},
+	reflect.TypeOf(NcConvertJob{}):   {"jobName", "sourceDir", "destinationDir"},
+	reflect.TypeOf(BookStackJob{}):   {"jobName", "destinationDir"},
+}
+
+// Returns the JSON schema (draft 2020-12) of the job file.
+// It is generated from the structs, so that it is always in sync with the parser
+func JobFileSchema() map[string]interface{} {
+	definitions := make(map[string]interface{})
+	root := reflect.TypeOf(NcConvertUsers{})
+	getSchema(root, definitions)
+	rtc := definitions[root.Name()].(map[string]interface{})
+	delete(definitions, root.Name())
+
+	rtc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	rtc["title"] = "ncDocConverter job file"
+	rtc["$defs"] = definitions
+
+	return rtc
+}
+
+// Returns the schema of the type. Structs are added to the definitions and referenced
+func getSchema(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Secret("")):
+		return map[string]interface{}{
+			"type":        "string",
+			"description": "The value itself, an environment variable (\"${MY_VAR}\") or a file (\"file:/run/secrets/password\")",
+		}
+	case reflect.TypeOf(StringBool(false)):
+		return map[string]interface{}{
+			"type": []string{"boolean", "string"},
+			"enum": []interface{}{true, false, "true", "false"},
+		}
+	}
+	if enum, exists := schemaEnums[t]; exists {
+		return map[string]interface{}{
+			"type": "string",
+			"enum": enum,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, exists := definitions[t.Name()]; !exists {
+			// Prevent an endless recursion for recursive types
+			definitions[t.Name()] = nil
+
+			properties := make(map[string]interface{})
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if !field.IsExported() || name == "-" {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+				if field.Type == reflect.TypeOf(Format("")) {
+					properties[name] = getFormatSchema(schemaFormats[t])
+				} else {
+					properties[name] = getSchema(field.Type, definitions)
+				}
+			}
+
+			definition := map[string]interface{}{
+				"type":                 "object",
+				"properties":           properties,
+				"additionalProperties": false,
+			}
+			if required, exists := schemaRequired[t]; exists {
+				definition["required"] = required
+			}
+			definitions[t.Name()] = definition
+		}
+
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": getSchema(t.Elem(), definitions),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// Returns the schema of a format that accepts the given formats in any case.
+// The formats are also given as examples for the completion of editors
+func getFormatSchema(formats []Format) map[string]interface{} {
+	examples := make([]string, len(formats))
+	patterns := make([]string, len(formats))
+	for i, format := range formats {
+		examples[i] = string(format)
+
+		// JSON schema has no flag for case-insensitive patterns
+		var pattern strings.Builder
+		for _, char := range string(format) {
+			upper, lower := strings.ToUpper(string(char)), strings.ToLower(string(char))
+			if upper == lower {
+				pattern.WriteString(regexp.QuoteMeta(string(char)))
+			} else {
+				pattern.WriteString("[" + lower + upper + "]")
+			}
+		}
+		patterns[i] = pattern.String()
+	}
+
+	return map[string]interface{}{
+		"type":     "string",
+		"pattern":  "^(" + strings.Join(patterns, "|") + ")$",
+		"examples": examples,
+	}
+}
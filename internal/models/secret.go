@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// A credential in the job or config file. Instead of the value itself, a reference can be given
+// that is resolved when the file is loaded:
+//   - "${MY_VAR}": the value of the environment variable MY_VAR
+//   - "file:/run/secrets/password": the content of the file without a trailing newline
+//     (e.g. a mounted Kubernetes secret)
+type Secret string
+
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	resolved, err := ResolveSecret(value)
+	if err != nil {
+		return err
+	}
+	*s = Secret(resolved)
+
+	return nil
+}
+
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+
+	resolved, err := ResolveSecret(value)
+	if err != nil {
+		return fmt.Errorf("line %d: %s", node.Line, err)
+	}
+	*s = Secret(resolved)
+
+	return nil
+}
+
+// Returns true if the value references an environment variable or a file
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, "file:") || (strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}"))
+}
+
+// Returns the value of a secret reference ("${MY_VAR}" or "file:/path").
+// Other values are returned unchanged
+func ResolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "file:") {
+		path := strings.TrimPrefix(value, "file:")
+		if path == "" {
+			return "", fmt.Errorf("no path given in the secret reference '%s'", value)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the secret file '%s': %s", path, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+		name := value[2 : len(value)-1]
+		if name == "" {
+			return "", fmt.Errorf("no environment variable given in the secret reference '%s'", value)
+		}
+
+		rtc, exists := os.LookupEnv(name)
+		if !exists {
+			return "", fmt.Errorf("the environment variable '%s' referenced by a secret is not set", name)
+		}
+		return rtc, nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,29 @@
+package models
+
+// Type of the backend to save the converted files in
+type StorageType string
+
+const (
+	// The Nextcloud instance of the user (WebDAV)
+	NextcloudStorage StorageType = "nextcloud"
+	// A directory of the local filesystem (e.g. a mounted NFS share)
+	LocalStorage StorageType = "local"
+	// A generic WebDAV server (e.g. Apache mod_dav, rclone or a NAS)
+	WebDAVStorage StorageType = "webdav"
+)
+
+// Source or destination backend of a job
+type Storage struct {
+	// Type of the storage. Defaults to "nextcloud"
+	Type StorageType `json:"type"`
+
+	// Root directory for the local storage.
+	// The destinationDir of the job is relative to this path
+	Path string `json:"path"`
+
+	// Root URL of the WebDAV storage (https://nas.myDomain.de/dav/).
+	// The directories of the job are relative to this URL
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password Secret `json:"password"`
+}
@@ -0,0 +1,533 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+)
+
+// A single problem of the job file
+type ValidationError struct {
+	// JSON path of the invalid value (nextcloudUsers[0].jobs[1].execution)
+	Path string
+	// Line of the value in the job file (0 if unknown)
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	location := e.Path
+	if location == "" {
+		location = "job file"
+	}
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s (line %d)", location, e.Line)
+	}
+
+	return location + ": " + e.Message
+}
+
+// All problems of the job file
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Collects the problems of a job file
+type validator struct {
+	// Line numbers indexed by the JSON path
+	lines  map[string]int
+	errors ValidationErrors
+}
+
+func (v *validator) report(path string, format string, a ...interface{}) {
+	v.errors = append(v.errors, &ValidationError{
+		Path:    path,
+		Line:    v.lineOf(path),
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// Returns the collected errors or nil if there are none
+func (v *validator) err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+
+	return v.errors
+}
+
+// Returns the line of the value. For values that are not given in the
+// file, the line of the parent is returned
+func (v *validator) lineOf(path string) int {
+	for {
+		if line, exists := v.lines[path]; exists {
+			return line
+		}
+		if path == "" {
+			return 0
+		}
+
+		index := strings.LastIndexAny(path, ".[")
+		if index < 0 {
+			path = ""
+		} else {
+			path = path[:index]
+		}
+	}
+}
+
+// Returns the line numbers of all values of the JSON document indexed by their path.
+// For the fields of an object, the line of the key is used
+func getJSONLines(content []byte) map[string]int {
+	var newlines []int
+	for i, c := range content {
+		if c == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+
+	rtc := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(content))
+	lineAt := func() int {
+		return sort.SearchInts(newlines, int(dec.InputOffset())) + 1
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, exists := rtc[path]; !exists {
+			rtc[path] = lineAt()
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child := joinPath(path, fmt.Sprint(key))
+				rtc[child] = lineAt()
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+
+		return err
+	}
+	walk("")
+
+	return rtc
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Checks if the decoded JSON value matches the given type.
+// Unknown fields, wrong types and values that are rejected by the type itself
+// (e.g. a secret reference that can't be resolved) are reported.
+// Unknown fields and invalid values inside the value are removed, so that the rest can still
+// be decoded. Returns false if the value itself is invalid
+func (v *validator) checkValue(value interface{}, t reflect.Type, path string) bool {
+	if value == nil {
+		return true
+	}
+
+	// Types with their own parsing like secrets
+	if t.Kind() != reflect.Struct && reflect.PtrTo(t).Implements(unmarshalerType) {
+		data, _ := json.Marshal(value)
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+			v.report(path, "%s", err)
+			return false
+		}
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.report(path, "expected an object")
+			return false
+		}
+
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := joinPath(path, key)
+			field, exists := fields[key]
+			if !exists {
+				suggestion := ""
+				for name := range fields {
+					if strings.EqualFold(name, key) {
+						suggestion = fmt.Sprintf(" (did you mean '%s'?)", name)
+					}
+				}
+				v.report(child, "unknown field '%s'%s", key, suggestion)
+				delete(object, key)
+				continue
+			}
+			if !v.checkValue(object[key], field.Type, child) {
+				delete(object, key)
+			}
+		}
+	case reflect.Slice:
+		array, ok := value.([]interface{})
+		if !ok {
+			v.report(path, "expected an array")
+			return false
+		}
+		for i, element := range array {
+			if !v.checkValue(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i)) {
+				array[i] = nil
+			}
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			v.report(path, "expected a string")
+			return false
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.report(path, "expected a boolean (true or false)")
+			return false
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			v.report(path, "expected an integer")
+			return false
+		}
+	}
+
+	return true
+}
+
+// Parses the JSON job file strictly. Unknown fields and values of the wrong type are left out,
+// so that the remaining values can still be validated. These problems are reported by Validate
+// together with the invalid values. If the line numbers are nil, they are determined from the JSON content
+func parseConvertUsers(content []byte, lines map[string]int) (*NcConvertUsers, error) {
+	var raw interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			line := bytes.Count(content[:syntaxError.Offset], []byte("\n")) + 1
+			return nil, ValidationErrors{{Line: line, Message: err.Error()}}
+		}
+		return nil, err
+	}
+
+	if lines == nil {
+		lines = getJSONLines(content)
+	}
+	v := validator{lines: lines}
+	if !v.checkValue(raw, reflect.TypeOf(NcConvertUsers{}), "") {
+		raw = nil
+	}
+	content, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := NcConvertUsers{lines: v.lines, errors: v.errors}
+	if err := json.Unmarshal(content, &conv); err != nil {
+		return nil, err
+	}
+
+	return &conv, nil
+}
+
+// Checks the jobs of all users for invalid values and missing credentials. The unknown fields and
+// values of the wrong type that were found while parsing are also returned.
+// If requireExecution is true, every job needs a cron expression
+func (users *NcConvertUsers) Validate(requireExecution bool) error {
+	v := validator{lines: users.lines}
+	destinations := make(map[string][]destination)
+
+	for ui, user := range users.Users {
+		userPath := fmt.Sprintf("nextcloudUsers[%d]", ui)
+
+		v.checkURL(userPath+".nextcloudUrl", user.NextcloudBaseUrl)
+		if user.Username == "" {
+			v.report(userPath+".username", "no username given")
+		}
+		if user.Password == "" {
+			v.report(userPath+".password", "no password given (set it, reference a secret or save an app password with the command 'login')")
+		}
+		switch user.Client.Listing {
+		case "", AutoListing, SearchListing, PropfindListing:
+		default:
+			v.report(userPath+".client.listing", "invalid listing strategy '%s'. Expected 'auto', 'search' or 'propfind'", user.Client.Listing)
+		}
+		v.checkConverter(userPath+".converter", user.Converter)
+
+		jobNames := make(map[string]bool)
+		for i, job := range user.ConvertJobs {
+			jobPath := fmt.Sprintf("%s.jobs[%d]", userPath, i)
+
+			v.checkJobName(jobPath, job.JobName, jobNames)
+			v.checkExecution(jobPath+".execution", job.Execution, requireExecution)
+			if job.SourceDir == "" {
+				v.report(jobPath+".sourceDir", "no source directory given")
+			}
+			format := job.Format.Normalize()
+			if !format.IsOfficeFormat() {
+				v.report(jobPath+".format", "unsupported format '%s'. Expected one of %v", job.Format, OfficeFormats)
+			}
+			for ti, sourceType := range job.SourceTypes {
+				if _, exists := OfficeContentTypes[strings.TrimPrefix(strings.ToLower(sourceType), ".")]; !exists {
+					v.report(fmt.Sprintf("%s.sourceTypes[%d]", jobPath, ti), "unsupported source type '%s'", sourceType)
+				} else if format.IsOfficeFormat() && !format.SupportsSource(sourceType) {
+					v.report(fmt.Sprintf("%s.sourceTypes[%d]", jobPath, ti), "'%s' documents can't be converted to '%s'. They can only be converted to %v",
+						sourceType, format, sheetFormats)
+				}
+			}
+
+			if job.Source.Type == LocalStorage {
+				v.report(jobPath+".source.type", "the local storage is not supported as a source")
+			} else {
+				v.checkStorage(jobPath+".source", job.Source)
+			}
+			converter := user.Converter
+			if job.Converter.Type != "" {
+				converter = job.Converter
+			}
+			if job.Source.Type == WebDAVStorage && converter.Type != CollaboraConverter {
+				v.report(jobPath+".converter", "only the converter 'collabora' supports a generic WebDAV server as the source")
+			}
+			v.checkConverter(jobPath+".converter", job.Converter)
+			v.checkDestination(jobPath, job.DestinationDir, job.Destination, job.Deletion)
+
+			key := getDestinationKey(job.Destination, user)
+			destinations[key] = append(destinations[key], destination{path: jobPath, jobName: job.JobName, dir: job.DestinationDir})
+		}
+
+		if len(user.BookStack.Jobs) > 0 {
+			v.checkURL(userPath+".bookStack.url", user.BookStack.URL)
+			if user.BookStack.Token == "" {
+				v.report(userPath+".bookStack.apiToken", "no API token given")
+			}
+		}
+		jobNames = make(map[string]bool)
+		for i, job := range user.BookStack.Jobs {
+			jobPath := fmt.Sprintf("%s.bookStack.jobs[%d]", userPath, i)
+
+			v.checkJobName(jobPath, job.JobName, jobNames)
+			v.checkExecution(jobPath+".execution", job.Execution, requireExecution)
+			v.checkRegex(jobPath+".shelveRegex", job.ShelvesRegex)
+			v.checkRegex(jobPath+".booksRegex", job.BooksRegex)
+			switch strings.ToLower(string(job.Format)) {
+			case string(HTML), string(PDF):
+			default:
+				v.report(jobPath+".format", "unsupported format '%s'. Expected 'html' or 'pdf'", job.Format)
+			}
+			v.checkDestination(jobPath, job.DestinationDir, job.Destination, job.Deletion)
+
+			key := getDestinationKey(job.Destination, user)
+			destinations[key] = append(destinations[key], destination{path: jobPath, jobName: job.JobName, dir: job.DestinationDir})
+		}
+	}
+
+	// Jobs would delete the files of each other
+	keys := make([]string, 0, len(destinations))
+	for key := range destinations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		jobs := destinations[key]
+		for i := 1; i < len(jobs); i++ {
+			for j := 0; j < i; j++ {
+				if isOverlapping(jobs[i].dir, jobs[j].dir) {
+					v.report(jobs[i].path+".destinationDir", "the destination directory '%s' overlaps with the one of the job '%s' (%s)",
+						jobs[i].dir, jobs[j].jobName, jobs[j].path)
+				}
+			}
+		}
+	}
+
+	return mergeErrors(users.errors, v.errors)
+}
+
+// Returns the errors of the parsing followed by the ones of the validation.
+// Values that were left out because they are invalid are only reported once
+func mergeErrors(parsing ValidationErrors, validation ValidationErrors) error {
+	rtc := append(ValidationErrors{}, parsing...)
+	for _, err := range validation {
+		reported := false
+		for _, parseErr := range parsing {
+			if err.Path == parseErr.Path || strings.HasPrefix(err.Path, parseErr.Path+".") || strings.HasPrefix(err.Path, parseErr.Path+"[") {
+				reported = true
+				break
+			}
+		}
+		if !reported {
+			rtc = append(rtc, err)
+		}
+	}
+
+	if len(rtc) == 0 {
+		return nil
+	}
+	return rtc
+}
+
+// Destination directory of a job
+type destination struct {
+	path    string
+	jobName string
+	dir     string
+}
+
+// Returns a key that is equal for all jobs writing into the same storage
+func getDestinationKey(storage Storage, user NextcloudUser) string {
+	switch storage.Type {
+	case LocalStorage:
+		return "local:" + path.Clean(storage.Path)
+	case WebDAVStorage:
+		return "webdav:" + strings.TrimSuffix(storage.URL, "/")
+	default:
+		return "nextcloud:" + strings.ToLower(user.Username) + "@" + strings.TrimSuffix(user.NextcloudBaseUrl, "/")
+	}
+}
+
+// Returns true if one directory is located inside the other one
+func isOverlapping(a string, b string) bool {
+	a = strings.Trim(path.Clean("/"+a), "/")
+	b = strings.Trim(path.Clean("/"+b), "/")
+	if a == "" || b == "" {
+		return true
+	}
+
+	return strings.HasPrefix(a+"/", b+"/") || strings.HasPrefix(b+"/", a+"/")
+}
+
+func (v *validator) checkURL(path string, value string) {
+	if value == "" {
+		v.report(path, "no URL given")
+		return
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		v.report(path, "invalid URL '%s'. Expected an URL like 'https://cloud.myDomain.de'", value)
+	}
+}
+
+func (v *validator) checkJobName(jobPath string, name string, names map[string]bool) {
+	if name == "" {
+		v.report(jobPath+".jobName", "no job name given")
+	} else if names[name] {
+		v.report(jobPath+".jobName", "the job name '%s' is used more than once", name)
+	}
+	names[name] = true
+}
+
+func (v *validator) checkExecution(path string, execution string, required bool) {
+	if execution == "" {
+		if required {
+			v.report(path, "no execution (cron expression) given")
+		}
+		return
+	}
+
+	if _, err := cron.ParseStandard(execution); err != nil {
+		v.report(path, "invalid cron expression '%s': %s", execution, err)
+	}
+}
+
+func (v *validator) checkRegex(path string, expression string) {
+	if expression == "" {
+		return
+	}
+
+	if _, err := regexp.Compile(expression); err != nil {
+		v.report(path, "invalid regex '%s': %s", expression, err)
+	}
+}
+
+func (v *validator) checkConverter(path string, converter Converter) {
+	switch converter.Type {
+	case "", OnlyOfficeConverter:
+	case DocumentServerConverter, CollaboraConverter:
+		v.checkURL(path+".url", converter.URL)
+	default:
+		v.report(path+".type", "invalid converter type '%s'. Expected 'onlyoffice', 'documentserver' or 'collabora'", converter.Type)
+	}
+}
+
+func (v *validator) checkStorage(path string, storage Storage) {
+	switch storage.Type {
+	case "", NextcloudStorage:
+	case LocalStorage:
+		if storage.Path == "" {
+			v.report(path+".path", "no path given for the local storage")
+		}
+	case WebDAVStorage:
+		v.checkURL(path+".url", storage.URL)
+	default:
+		v.report(path+".type", "invalid storage type '%s'. Expected 'nextcloud', 'webdav' or 'local'", storage.Type)
+	}
+}
+
+func (v *validator) checkDestination(jobPath string, destinationDir string, storage Storage, deletion Deletion) {
+	if destinationDir == "" {
+		v.report(jobPath+".destinationDir", "no destination directory given")
+	}
+	v.checkStorage(jobPath+".destination", storage)
+	if err := deletion.Validate(destinationDir); err != nil {
+		v.report(jobPath+".deletion", "%s", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 type WebConfig struct {
 	Server  Server  `yaml:"server"`
 	Logging Logging `yaml:"logging"`
+	Auth    Auth    `yaml:"auth"`
 }
 
 type Server struct {
@@ -20,7 +21,13 @@ type Server struct {
 	Certificate string `yaml:"certificate"`
 	OneShot     bool   `yaml:"oneShot"`
 	JobFile     string `yaml:"jobFile"`
-	Version     string
+	DataDir     string `yaml:"dataDir"`
+	DryRun      bool   `yaml:"dryRun"`
+	// Maximum seconds to wait for running jobs when shutting down. This includes the cancellation of the jobs
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+	// Seconds to wait for further webhook events before a job is executed
+	WebhookDebounce int `yaml:"webhookDebounce"`
+	Version         string
 }
 
 type Logging struct {
@@ -52,6 +59,10 @@ func getDefaultConfig() *WebConfig {
 		Server: Server{
 			Address: ":4000",
 			JobFile: utils.GetEnvString("DATA_FILE", "./ncConverter.json"),
+			DataDir: utils.GetEnvString("DATA_DIR", "./data"),
+			// Kubernetes kills the container after 30 seconds by default
+			ShutdownTimeout: 25,
+			WebhookDebounce: 10,
 		},
 		Logging: Logging{
 			PrintLogLevel: "info",
@@ -84,12 +95,14 @@ func SetConfig(version string) (*WebConfig, error) {
 	address := flag.String("address", webConfig.Server.Address, "Address and port on which the api and the web server should listen to")
 	printLogLevel := flag.String("printLogLevel", webConfig.Logging.PrintLogLevel, "Minimum log level to log (debug, info, warning, error, fatal)")
 	oneShot := flag.Bool("oneShot", webConfig.Server.OneShot, "All jobs are executed immediately and the program exists afterwards")
+	dryRun := flag.Bool("dry-run", webConfig.Server.DryRun, "Only print the planned changes of all jobs without modifying any files")
 	printVersion := flag.Bool("version", false, "Prints the version of the program")
 
 	flag.Parse()
 	webConfig.Server.Address = *address
 	webConfig.Logging.PrintLogLevel = *printLogLevel
 	webConfig.Server.OneShot = *oneShot
+	webConfig.Server.DryRun = *dryRun
 
 	if *printVersion {
 		fmt.Println(webConfig.Server.Version)
@@ -3,18 +3,24 @@ package ncworker
 // @TODO delete folders for shelves that doesn't exist anyore
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
 	"git.rpjosh.de/ncDocConverter/internal/models"
-	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/internal/storage"
 	"git.rpjosh.de/ncDocConverter/pkg/utils"
 )
 
@@ -22,6 +28,14 @@ type BsJob struct {
 	job    *models.BookStackJob
 	ncUser *models.NextcloudUser
 
+	// Destination to save the converted books in
+	storage storage.Storage
+
+	// Shared dependencies like the persistent state
+	env *Environment
+	// Key of the job inside the state
+	key string
+
 	cacheCount   int
 	cacheBooks   map[int]book
 	cacheShelves []shelf
@@ -82,48 +96,154 @@ type bookDetails struct {
 	Tags []string `json:"tags"`
 }
 
-func NewBsJob(job *models.BookStackJob, ncUser *models.NextcloudUser) *BsJob {
+type bookQueu struct {
+	book        book
+	destination string
+	reason      string
+}
+
+// All actions to perform in an execution of the job
+type bookStackPlan struct {
+	booksToConvert []bookQueu
+	filesToDelete  []storage.File
+	directorys     []string
+
+	// Up to date books that are not contained in the state yet
+	adopted []state.Entry
+	// IDs of the books that are not available anymore and whose converted file is not deleted
+	forgotten []string
+	// IDs of the books that are not available anymore indexed by the path of their converted file
+	// that is deleted. They are forgotten when the file was deleted
+	orphanedSources map[string][]string
+}
+
+func NewBsJob(job *models.BookStackJob, ncUser *models.NextcloudUser, env *Environment) (*BsJob, error) {
+	if err := job.Deletion.Validate(job.DestinationDir); err != nil {
+		return nil, err
+	}
+	client, err := getClient(job.Destination, ncUser)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storage.New(job.Destination, client, getJobKey("bookstack", ncUser, job.JobName))
+	if err != nil {
+		return nil, err
+	}
+
 	bsJob := BsJob{
-		job:    job,
-		ncUser: ncUser,
+		job:     job,
+		ncUser:  ncUser,
+		env:     env,
+		storage: storage,
+		key:     getJobKey("bookstack", ncUser, job.JobName),
+	}
+
+	return &bsJob, nil
+}
+
+// Returned when a requested book does not exist (anymore)
+var errBookNotFound = errors.New("book does not exist")
+
+// Records the duration of the requests to BookStack
+var bookStackTransport = metrics.InstrumentTransport("bookstack", http.DefaultTransport)
+
+func (job *BsJob) ExecuteJob(ctx context.Context) (*RunResult, error) {
+	plan, err := job.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.execute(ctx, plan)
+}
+
+// Converts only the given books again (e.g. after a page was edited).
+// If a book was not converted by this job before or was renamed or deleted, the whole job is executed instead
+func (job *BsJob) ExecuteBooks(ctx context.Context, ids []int) (*RunResult, error) {
+	plan := &bookStackPlan{}
+	for _, id := range ids {
+		b, err := job.getBook(id)
+		if errors.Is(err, errBookNotFound) {
+			logger.Info("Book %d of BookStack job \"%s\" does not exist anymore → executing the whole job", id, job.job.JobName)
+			return job.ExecuteJob(ctx)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get book %d: %s", id, err)
+		}
+		if !job.matchesBook(b.Name) {
+			logger.Debug("Ignoring book %s of BookStack job \"%s\"", b.Name, job.job.JobName)
+			continue
+		}
+
+		entry, known := job.env.State.Get(job.key, strconv.Itoa(id))
+		if !known || entry.SourcePath != b.Name || b.lastModified.Unix() == 0 {
+			logger.Info("Book %s is new or was renamed → executing the whole BookStack job \"%s\"", b.Name, job.job.JobName)
+			return job.ExecuteJob(ctx)
+		}
+
+		plan.booksToConvert = append(plan.booksToConvert, bookQueu{book: *b, destination: entry.Destination, reason: reasonModified})
+	}
+
+	return job.execute(ctx, plan)
+}
+
+// Applies the plan or only reports it for a dry run
+func (job *BsJob) execute(ctx context.Context, plan *bookStackPlan) (*RunResult, error) {
+	if job.env.IsStopping() {
+		return &RunResult{}, nil
+	}
+
+	if job.job.DryRun || job.env.Config.Server.DryRun {
+		publicPlan := job.getPlan(plan)
+		publicPlan.report(job.env.Config.Server.DataDir)
+		return &RunResult{Plan: publicPlan}, nil
 	}
 
-	return &bsJob
+	return job.apply(ctx, plan), nil
 }
 
-func (job *BsJob) ExecuteJob() {
-	// Get all existing files in the destination folder
-	destination, err := nextcloud.SearchInDirectory(
-		job.ncUser, job.job.DestinationDir,
+// Determines which books have to be converted and which files have to be deleted.
+// No files are modified
+func (job *BsJob) plan(ctx context.Context) (*bookStackPlan, error) {
+	fileExtension, _, err := job.getFileExtension()
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all existing files in the destination folder (indexed by path)
+	destinationMap, err := job.storage.List(
+		ctx,
+		job.job.DestinationDir,
 		[]string{
 			"text/html",
 			"application/pdf",
 		},
 	)
-	if err != nil {
-		logger.Error("Failed to get files in destination directory '%s': %s", job.job.DestinationDir, err)
-		return
+	if errors.Is(err, storage.ErrNotExist) {
+		destinationMap = make(map[string]storage.File)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get files in destination directory '%s': %s", job.job.DestinationDir, err)
 	}
-
-	// Make a map with path as index
-	prefix := "/remote.php/dav/files/" + job.ncUser.Username + "/"
-	destinationMap := nextcloud.ParseSearchResult(destination, prefix, job.job.DestinationDir)
+	destinationCount := len(destinationMap)
 
 	// Check for cache
 	job.cache()
+	if job.job.CacheCount > 0 {
+		result := "miss"
+		if job.useCache {
+			result = "hit"
+		}
+		metrics.BookStackCache.WithLabelValues(getJobUser(job.ncUser), job.job.JobName, result).Inc()
+	}
 
 	// Get all shelves
 	shelves, err := job.getShelves()
 	if err != nil {
-		logger.Error("Failed to get shelves: %s", err)
-		return
+		return nil, fmt.Errorf("failed to get shelves: %s", err)
 	}
 
 	// Get all books
 	books, err := job.getBooks()
 	if err != nil {
-		logger.Error("Failed to get books: %s", err)
-		return
+		return nil, fmt.Errorf("failed to get books: %s", err)
 	}
 
 	// Index books by path
@@ -137,63 +257,171 @@ func (job *BsJob) ExecuteJob() {
 		job.cacheBooks = utils.CopyMap(*books)
 	}
 
-	// Now finally convert the books :)
-	convertCount := 0
-	var wg sync.WaitGroup
+	plan := &bookStackPlan{}
+	bookIDs := make(map[string]bool)
 	for i, b := range indexedBooks {
 		// mark as converted
 		indexedBooks[i].converted = true
 		(*books)[b.ID] = *indexedBooks[i]
 
-		// check if it has to be converted again (updated) or for the first time
-		des, exists := destinationMap[i]
-
-		if (!exists || b.lastModified.After(des.LastModified)) && !b.ignore {
-			wg.Add(1)
-			convertCount++
-			go func(book book, path string) {
-				defer wg.Done()
-				job.convertBook(book, path)
-			}(*b, i)
-		} else if b.ignore {
+		if b.ignore {
 			logger.Debug("Duplicate book name: %s", b.Name)
+			continue
 		}
 
+		bookIDs[strconv.Itoa(b.ID)] = true
+		job.planBook(plan, b, i, fileExtension, destinationMap)
+
 		// Ignore states that a book with a duplicate name exists → delete the orig also
-		if !b.ignore {
-			delete(destinationMap, i)
-		}
+		delete(destinationMap, i)
 	}
-	wg.Wait()
 
 	// Convert remaining books
 	if job.job.IncludeBooksWithoutShelve {
 		for _, b := range *books {
-			// check if it has to be converted again (updated) or for the first time
-			des, exists := destinationMap[b.Name]
-
-			if !b.converted && !b.ignore && (!exists || b.lastModified.After(des.LastModified)) {
-				wg.Add(1)
-				convertCount++
-				go func(book book, path string) {
-					defer wg.Done()
-					job.convertBook(book, path)
-				}(b, b.Name)
+			if !b.converted && !b.ignore {
+				bookIDs[strconv.Itoa(b.ID)] = true
+				job.planBook(plan, &b, b.Name, fileExtension, destinationMap)
 			}
 			delete(destinationMap, b.Name)
 		}
-		wg.Wait()
 	}
 
 	// Delete the files which are not available anymore
+	orphaned := make([]storage.File, 0, len(destinationMap))
 	for _, dest := range destinationMap {
-		err := nextcloud.DeleteFile(job.ncUser, dest.Path)
+		orphaned = append(orphaned, dest)
+	}
+	var aborted bool
+	plan.filesToDelete, aborted = getFilesToDelete(job.job.JobName, &job.job.Deletion, orphaned, destinationCount)
+
+	// Forget the books which are not available anymore
+	plan.forgotten, plan.orphanedSources = getForgottenSources(job.env.State.Entries(job.key), bookIDs, plan.filesToDelete, aborted)
+
+	return plan, nil
+}
+
+// Checks if the book has to be converted again (updated) or for the first time
+// and adds it to the plan
+func (job *BsJob) planBook(plan *bookStackPlan, b *book, path string, fileExtension string, destinationMap map[string]storage.File) {
+	dest, exists := destinationMap[path]
+	if !exists {
+		destination := job.job.DestinationDir + path + fileExtension
+		appendIfNotExists(&plan.directorys, destination[0:strings.LastIndex(destination, "/")+1])
+
+		plan.booksToConvert = append(plan.booksToConvert, bookQueu{book: *b, destination: destination, reason: reasonNew})
+	} else if !job.isUpToDate(b, &dest) {
+		plan.booksToConvert = append(plan.booksToConvert, bookQueu{book: *b, destination: dest.Path, reason: reasonModified})
+	} else if _, known := job.env.State.Get(job.key, strconv.Itoa(b.ID)); !known {
+		plan.adopted = append(plan.adopted, getBookStateEntry(b, dest.Path, dest.LastModified))
+	}
+}
+
+// Executes the actions of the plan
+func (job *BsJob) apply(ctx context.Context, plan *bookStackPlan) *RunResult {
+	result := &RunResult{}
+	for _, dir := range plan.directorys {
+		if err := job.storage.Mkdir(ctx, dir); err != nil {
+			logger.Error("Failed to create directory '%s': %s", dir, err)
+		}
+	}
+
+	// Now finally convert the books :)
+	var wg sync.WaitGroup
+	wg.Add(len(plan.booksToConvert))
+	for _, b := range plan.booksToConvert {
+		go func(b bookQueu) {
+			defer wg.Done()
+			if job.env.IsStopping() {
+				return
+			}
+			if err := job.convertBook(ctx, b.book, b.destination); err != nil {
+				logger.Error("%s", utils.FirstCharToUppercase(err.Error()))
+				atomic.AddInt32(&result.Failed, 1)
+				metrics.DocumentsFailed.WithLabelValues(job.getMetricLabels(true)...).Inc()
+				return
+			}
+			atomic.AddInt32(&result.Converted, 1)
+			metrics.DocumentsConverted.WithLabelValues(job.getMetricLabels(true)...).Inc()
+		}(b)
+	}
+	wg.Wait()
+
+	// Delete the files which are not available anymore
+	for _, dest := range plan.filesToDelete {
+		if job.env.IsStopping() {
+			break
+		}
+		err := removeFile(ctx, job.storage, &job.job.Deletion, job.job.DestinationDir, dest)
 		if err != nil {
 			logger.Error(utils.FirstCharToUppercase(err.Error()))
+			result.Failed++
+			metrics.DocumentsFailed.WithLabelValues(job.getMetricLabels(true)...).Inc()
+			continue
+		}
+		result.Deleted++
+		metrics.DocumentsDeleted.WithLabelValues(job.getMetricLabels(false)...).Inc()
+
+		for _, id := range plan.orphanedSources[dest.Path] {
+			job.env.State.Delete(job.key, id)
 		}
 	}
 
-	logger.Info("Finished BookStack job \"%s\": %d books converted", job.job.JobName, convertCount)
+	// Update the state
+	for _, entry := range plan.adopted {
+		job.env.State.Set(job.key, entry)
+	}
+	for _, id := range plan.forgotten {
+		job.env.State.Delete(job.key, id)
+	}
+	if err := job.env.State.Save(); err != nil {
+		logger.Error("Failed to save the state of job \"%s\": %s", job.job.JobName, err)
+	}
+
+	logger.Info("Finished BookStack job \"%s\": %d books converted", job.job.JobName, result.Converted)
+	return result
+}
+
+// Returns the public representation of the plan
+func (job *BsJob) getPlan(plan *bookStackPlan) *Plan {
+	rtc := newPlan("bookstack", job.job.JobName, job.ncUser.Username)
+
+	rtc.Directories = append(rtc.Directories, plan.directorys...)
+	for _, b := range plan.booksToConvert {
+		rtc.Conversions = append(rtc.Conversions, PlanConversion{Source: b.book.Name, Destination: b.destination, Reason: b.reason})
+	}
+	addDeletionsToPlan(rtc, &job.job.Deletion, job.job.DestinationDir, plan.filesToDelete)
+	rtc.sort()
+
+	return rtc
+}
+
+// Returns true if the destination file was converted from the current version of the book.
+// When the book is unknown (e.g. converted by an older version), the modification
+// time is compared
+func (job *BsJob) isUpToDate(b *book, destination *storage.File) bool {
+	entry, exists := job.env.State.Get(job.key, strconv.Itoa(b.ID))
+	if exists {
+		return entry.ETag == getBookETag(b) && entry.Destination == destination.Path
+	}
+
+	return !b.lastModified.After(destination.LastModified)
+}
+
+// Returns the entry of the state for the converted book
+func getBookStateEntry(b *book, destination string, convertedAt time.Time) state.Entry {
+	return state.Entry{
+		SourceID:    strconv.Itoa(b.ID),
+		SourcePath:  b.Name,
+		ETag:        getBookETag(b),
+		Destination: destination,
+		ConvertedAt: convertedAt,
+	}
+}
+
+// BookStack does not provide an ETag for books → the last modification is used instead
+func getBookETag(b *book) string {
+	return b.lastModified.UTC().Format(time.RFC3339Nano)
 }
 
 // Checks and initializes the cache
@@ -223,7 +451,7 @@ func (job *BsJob) getShelves() (*[]shelf, error) {
 		return &job.cacheShelves, nil
 	}
 
-	client := http.Client{Timeout: 10 * time.Second}
+	client := http.Client{Timeout: 10 * time.Second, Transport: bookStackTransport}
 
 	req := job.getRequest(http.MethodGet, "shelves", nil)
 
@@ -251,8 +479,9 @@ func (job *BsJob) getShelves() (*[]shelf, error) {
 
 	if job.job.ShelvesRegex != "" {
 		reg, err := regexp.Compile(job.job.ShelvesRegex)
-		// This is fatal
-		logger.Fatal("Failed to parse the regex '%s': %s", job.job.ShelvesRegex, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the regex '%s': %s", job.job.ShelvesRegex, err)
+		}
 
 		rtc2 := shelves{}
 
@@ -272,7 +501,7 @@ func (job *BsJob) getShelves() (*[]shelf, error) {
 
 // Returns the IDs of books which belongs to the shelf
 func (job *BsJob) getBooksInShelve(id int) ([]int, error) {
-	client := http.Client{Timeout: 10 * time.Second}
+	client := http.Client{Timeout: 10 * time.Second, Transport: bookStackTransport}
 	req := job.getRequest(http.MethodGet, "shelves/"+fmt.Sprintf("%d", id), nil)
 
 	res, err := client.Do(req)
@@ -362,11 +591,6 @@ func (job *BsJob) getIndexedBooks(shelves *[]shelf, books *map[int]book) map[str
 				indexedBooks[bookPath] = &b
 			}
 		}
-
-		// If the structure should be keept, a folder for every shelve has to be created
-		if job.job.KeepStructure && !job.useCache {
-			nextcloud.CreateFoldersRecursively(job.ncUser, job.job.DestinationDir+shelf.Name+"/")
-		}
 	}
 
 	return indexedBooks
@@ -403,7 +627,7 @@ func (job *BsJob) getBooks() (*map[int]book, error) {
 		return &books, nil
 	}
 
-	client := http.Client{Timeout: 10 * time.Second}
+	client := http.Client{Timeout: 10 * time.Second, Transport: bookStackTransport}
 	req := job.getRequest(http.MethodGet, "books", nil)
 
 	// Add shelve filter
@@ -430,8 +654,9 @@ func (job *BsJob) getBooks() (*map[int]book, error) {
 
 	if job.job.BooksRegex != "" {
 		reg, err := regexp.Compile(job.job.BooksRegex)
-		// This is fatal
-		logger.Fatal("Failed to parse the regex '%s': %s", job.job.BooksRegex, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the regex '%s': %s", job.job.BooksRegex, err)
+		}
 
 		booksArray2 := books{}
 
@@ -482,7 +707,17 @@ func (job *BsJob) getBooks() (*map[int]book, error) {
 
 // Returns the last modified time of a book
 func (job *BsJob) getLastModifiedOfBook(id int) (*time.Time, error) {
-	client := http.Client{Timeout: 10 * time.Second}
+	b, err := job.getBook(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b.lastModified, nil
+}
+
+// Returns the book with its last modified time
+func (job *BsJob) getBook(id int) (*book, error) {
+	client := http.Client{Timeout: 10 * time.Second, Transport: bookStackTransport}
 	req := job.getRequest(http.MethodGet, "books/"+fmt.Sprintf("%d", id), nil)
 
 	res, err := client.Do(req)
@@ -491,7 +726,9 @@ func (job *BsJob) getLastModifiedOfBook(id int) (*time.Time, error) {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
+	if res.StatusCode == 404 {
+		return nil, errBookNotFound
+	} else if res.StatusCode != 200 {
 		return nil, fmt.Errorf("expected status code 200, got %d", res.StatusCode)
 	}
 
@@ -511,48 +748,82 @@ func (job *BsJob) getLastModifiedOfBook(id int) (*time.Time, error) {
 		}
 	}
 
-	return &lastMod, nil
+	return &book{ID: bd.ID, Name: bd.Name, lastModified: lastMod}, nil
+}
+
+// Returns true if the book name matches the book filters of the job
+func (job *BsJob) matchesBook(name string) bool {
+	return matchesFilter(name, job.job.Books, job.job.BooksRegex)
+}
+
+// Returns true if the shelf name matches the shelf filters of the job
+func (job *BsJob) matchesShelf(name string) bool {
+	return matchesFilter(name, job.job.Shelves, job.job.ShelvesRegex)
+}
+
+// Returns true if the name is contained in the names (if given) and matches the regex (if given)
+func matchesFilter(name string, names []string, regex string) bool {
+	if len(names) > 0 && !utils.Contains(names, name) {
+		return false
+	}
+	if regex != "" {
+		matches, err := regexp.MatchString(regex, name)
+		return err == nil && matches
+	}
+
+	return true
 }
 
 // Returns a new request to the bookStack API.
 // The path beginning AFTER /api/ should be given (e.g.: shelves)
 func (job *BsJob) getRequest(method string, path string, body io.Reader) *http.Request {
-	req, err := http.NewRequest(method, job.ncUser.BookStack.URL+"/api/"+path, body)
+	return newBookStackRequest(&job.ncUser.BookStack, method, path, body)
+}
+
+func newBookStackRequest(bookStack *models.BookStack, method string, path string, body io.Reader) *http.Request {
+	req, err := http.NewRequest(method, bookStack.URL+"/api/"+path, body)
 	if err != nil {
 		logger.Error("%s", err)
 	}
-	req.Header.Set("Authorization", "Token "+job.ncUser.BookStack.Token)
+	req.Header.Set("Authorization", "Token "+string(bookStack.Token))
 
 	return req
 }
 
-// Converts the given book and uploads it to nextcloud.
-// The path is being expected relative to the root dir of the jobs directory and does
-// not contain a file extension
-func (job *BsJob) convertBook(book book, path string) {
-	fileExtension, url := job.getFileExtension()
+// Converts the given book and saves it in the destination storage.
+// The full path of the destination file is expected
+func (job *BsJob) convertBook(ctx context.Context, book book, destination string) error {
+	_, url, err := job.getFileExtension()
+	if err != nil {
+		return err
+	}
 
-	client := http.Client{Timeout: 10 * time.Second}
-	req := job.getRequest(http.MethodGet, fmt.Sprintf("books/%d/export/%s", book.ID, url), nil)
+	// The export is streamed into the upload, so only the context of the job limits the duration
+	client := http.Client{Transport: bookStackTransport}
+	req := job.getRequest(http.MethodGet, fmt.Sprintf("books/%d/export/%s", book.ID, url), nil).WithContext(ctx)
 
 	res, err := client.Do(req)
 	if err != nil {
-		logger.Error("Failed to convert book: %s", err)
+		return fmt.Errorf("failed to convert book: %s", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		logger.Error("Failed to convert book: expected status code 200, got %d", res.StatusCode)
-		return
+		return fmt.Errorf("failed to convert book: expected status code 200, got %d", res.StatusCode)
 	}
 
-	err = nextcloud.UploadFile(job.ncUser, job.job.DestinationDir+path+fileExtension, res.Body)
+	reader := metrics.NewCountingReader(res.Body)
+	err = job.storage.Put(ctx, destination, reader, res.ContentLength, getBookETag(&book))
 	if err != nil {
-		logger.Error("Failed to upload book to nextcloud: %s", err)
+		return fmt.Errorf("failed to save book %s: %s", book.Name, err)
 	}
+	metrics.UploadedBytes.WithLabelValues(job.getMetricLabels(false)...).Add(float64(reader.Count()))
+
+	job.env.State.Set(job.key, getBookStateEntry(&book, destination, time.Now()))
+	return nil
 }
 
-func (job *BsJob) getFileExtension() (fileExtension string, url string) {
+func (job *BsJob) getFileExtension() (fileExtension string, url string, err error) {
 	switch strings.ToLower(string(job.job.Format)) {
 	case "html":
 		{
@@ -566,9 +837,19 @@ func (job *BsJob) getFileExtension() (fileExtension string, url string) {
 		}
 	default:
 		{
-			logger.Fatal("Invalid format given: '%s'. Expected 'html' or 'pdf'", job.job.Format)
+			err = fmt.Errorf("invalid format given: '%s'. Expected 'html' or 'pdf'", job.job.Format)
 		}
 	}
 
 	return
 }
+
+// Returns the labels of the job for the metrics
+func (job *BsJob) getMetricLabels(withFormat bool) []string {
+	rtc := []string{"bookstack", getJobUser(job.ncUser), job.job.JobName}
+	if withFormat {
+		rtc = append(rtc, strings.ToLower(string(job.job.Format)))
+	}
+
+	return rtc
+}
@@ -0,0 +1,229 @@
+package ncworker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+)
+
+// A BookStack instance with shelves and books that are kept in memory
+type fakeBookStack struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	shelves []fakeShelf
+	books   map[int]*fakeBook
+	// Paths of all received requests
+	requests []string
+}
+
+type fakeShelf struct {
+	id    int
+	name  string
+	books []int
+}
+
+type fakeBook struct {
+	name      string
+	updatedAt time.Time
+}
+
+func newFakeBookStack(t *testing.T, shelves []fakeShelf, books map[int]string) *fakeBookStack {
+	bs := &fakeBookStack{shelves: shelves, books: make(map[int]*fakeBook)}
+	for id, name := range books {
+		bs.books[id] = &fakeBook{name: name, updatedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+
+	bs.server = httptest.NewServer(http.HandlerFunc(bs.serve))
+	t.Cleanup(bs.server.Close)
+
+	return bs
+}
+
+func (bs *fakeBookStack) serve(w http.ResponseWriter, r *http.Request) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.requests = append(bs.requests, r.URL.Path)
+
+	filter := r.URL.Query()["filter[name:eq]"]
+	matches := func(name string) bool {
+		if len(filter) == 0 {
+			return true
+		}
+		for _, f := range filter {
+			if f == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	var id int
+	if len(parts) > 1 {
+		id, _ = strconv.Atoi(parts[1])
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "shelves":
+		data := []map[string]interface{}{}
+		for _, s := range bs.shelves {
+			if matches(s.name) {
+				data = append(data, map[string]interface{}{"id": s.id, "name": s.name})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case len(parts) == 2 && parts[0] == "shelves":
+		for _, s := range bs.shelves {
+			if s.id != id {
+				continue
+			}
+			books := []map[string]interface{}{}
+			for _, bookID := range s.books {
+				books = append(books, map[string]interface{}{"id": bookID, "name": bs.books[bookID].name})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": s.id, "name": s.name, "books": books})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case len(parts) == 1 && parts[0] == "books":
+		data := []map[string]interface{}{}
+		for bookID, b := range bs.books {
+			if matches(b.name) {
+				data = append(data, map[string]interface{}{"id": bookID, "name": b.name})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case len(parts) == 2 && parts[0] == "books":
+		b, exists := bs.books[id]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":       id,
+			"name":     b.name,
+			"contents": []map[string]interface{}{{"id": 1, "updated_at": b.updatedAt}},
+		})
+	case len(parts) == 4 && parts[0] == "books" && parts[2] == "export":
+		b, exists := bs.books[id]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%s (%s)", b.name, parts[3])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Returns a BookStack job that saves the books in a temporary directory
+func newTestBsJob(t *testing.T, bs *fakeBookStack, job models.BookStackJob) (*BsJob, string) {
+	dir := t.TempDir()
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open the state: %s", err)
+	}
+
+	if job.JobName == "" {
+		job.JobName = "wiki"
+	}
+	if job.Format == "" {
+		job.Format = models.HTML
+	}
+	job.Destination = models.Storage{Type: models.LocalStorage, Path: dir}
+	user := &models.NextcloudUser{
+		NextcloudBaseUrl: "https://cloud.local",
+		Username:         "user",
+		BookStack:        models.BookStack{URL: bs.server.URL, Token: "id:secret", Jobs: []models.BookStackJob{job}},
+	}
+
+	bsJob, err := NewBsJob(&user.BookStack.Jobs[0], user, NewEnvironment(&models.WebConfig{}, store))
+	if err != nil {
+		t.Fatalf("failed to create the job: %s", err)
+	}
+
+	return bsJob, dir
+}
+
+// Returns the relative paths of all files inside the directory
+func listFiles(t *testing.T, dir string) []string {
+	rtc := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rtc = append(rtc, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list the files: %s", err)
+	}
+	sort.Strings(rtc)
+
+	return rtc
+}
+
+func TestBsJobFilters(t *testing.T) {
+	bs := newFakeBookStack(t,
+		[]fakeShelf{{id: 1, name: "Work", books: []int{1, 2}}, {id: 2, name: "Private", books: []int{3}}},
+		map[int]string{1: "Runbook", 2: "Notes", 3: "Diary"},
+	)
+
+	tests := []struct {
+		name    string
+		job     models.BookStackJob
+		want    []string
+		wantErr bool
+	}{
+		{name: "all books", want: []string{"wiki/Diary.html", "wiki/Notes.html", "wiki/Runbook.html"}},
+		{name: "shelve regex", job: models.BookStackJob{ShelvesRegex: "^Wo"}, want: []string{"wiki/Notes.html", "wiki/Runbook.html"}},
+		{name: "books regex", job: models.BookStackJob{BooksRegex: "book$"}, want: []string{"wiki/Runbook.html"}},
+		{name: "both regexes", job: models.BookStackJob{ShelvesRegex: "Private", BooksRegex: "^D"}, want: []string{"wiki/Diary.html"}},
+		{name: "shelves and structure", job: models.BookStackJob{Shelves: []string{"Work"}, KeepStructure: true, Format: models.PDF},
+			want: []string{"wiki/Work/Notes.pdf", "wiki/Work/Runbook.pdf"}},
+		{name: "invalid shelve regex", job: models.BookStackJob{ShelvesRegex: "(["}, wantErr: true},
+		{name: "invalid books regex", job: models.BookStackJob{BooksRegex: "(["}, wantErr: true},
+		{name: "invalid format", job: models.BookStackJob{Format: "docx"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.job.DestinationDir = "wiki/"
+			job, dir := newTestBsJob(t, bs, tt.job)
+
+			result, err := job.ExecuteJob(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := listFiles(t, dir)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if int(result.Converted) != len(tt.want) || result.Failed != 0 {
+				t.Errorf("expected %d converted books without failures, got %+v", len(tt.want), result)
+			}
+		})
+	}
+}
@@ -1,15 +1,20 @@
 package ncworker
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"git.rpjosh.de/RPJosh/go-logger"
 	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/pkg/utils"
 	"github.com/go-co-op/gocron"
 )
 
@@ -18,63 +23,189 @@ type NcConvertScheduler struct {
 	config *models.WebConfig
 
 	scheduler *gocron.Scheduler
+	env       *Environment
+
+	// Canceled after the grace period when shutting down
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Jobs that are currently running
+	running sync.WaitGroup
+
+	// Closed when the jobs of the one shot mode finished
+	done chan struct{}
+
+	// Guards the scheduled jobs and the runs
+	mu sync.Mutex
+	// Scheduled jobs indexed by their key
+	jobs map[string]*scheduledJob
+	// Latest runs of all jobs (oldest first)
+	runs      []*Run
+	nextRunID int64
+	// Runs that are currently executed indexed by the job key
+	active map[string]*Run
+	// Changes received by webhooks that are not executed yet indexed by the job key
+	pending map[string]*pendingUpdate
+	// Hash of the last loaded job file
+	jobFileHash string
+
+	// Result of the last readiness checks
+	readiness readinessCache
+}
+
+// A job that is scheduled with gocron
+type scheduledJob struct {
+	id        string
+	name      string
+	jobType   string
+	jobName   string
+	user      string
+	execution string
+	job       Job
+	// JSON of the job and its user to detect changes
+	definition string
+	cronJob    *gocron.Job
+}
+
+// The definition of a job in the job file
+type jobDefinition struct {
+	name       string
+	jobType    string
+	jobName    string
+	user       string
+	execution  string
+	definition string
+	create     func() (Job, error)
 }
 
 func NewScheduler(users *models.NcConvertUsers, config *models.WebConfig) *NcConvertScheduler {
+	store, err := state.Open(config.Server.DataDir)
+	if err != nil {
+		logger.Fatal("Failed to open the state: %s", err)
+	}
+
+	scheduler := newScheduler(users, config, store)
+	if config.Server.OneShot {
+		scheduler.running.Add(1)
+		go func() {
+			defer scheduler.running.Done()
+			scheduler.ScheduleExecutionsOneShot()
+			close(scheduler.done)
+		}()
+	} else {
+		scheduler.ScheduleExecutions()
+		go scheduler.watchJobFile()
+		logger.Info("Started in schedule mode")
+	}
+
+	return scheduler
+}
+
+// Returns a started scheduler without any jobs
+func newScheduler(users *models.NcConvertUsers, config *models.WebConfig, store *state.Store) *NcConvertScheduler {
+	ctx, cancel := context.WithCancel(context.Background())
 	scheduler := NcConvertScheduler{
 		users:     users,
 		config:    config,
 		scheduler: gocron.NewScheduler(time.Local),
+		env:       NewEnvironment(config, store),
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*scheduledJob),
+		active:    make(map[string]*Run),
+		pending:   make(map[string]*pendingUpdate),
+		done:      make(chan struct{}),
 	}
+	scheduler.jobFileHash, _ = getFileHash(config.Server.JobFile)
 	// Don't reschedule a task if it's still running
 	scheduler.scheduler.SingletonMode()
 	scheduler.scheduler.StartAsync()
 
-	if config.Server.OneShot {
-		scheduler.ScheduleExecutionsOneShot()
-	} else {
-		scheduler.ScheduleExecutions()
+	return &scheduler
+}
 
-		fmt.Println("Started in schedule mode.\nType \"exit\" to leave or \"execute\" to execute all jobs")
-		// Endless loop
-		for {
-			reader := bufio.NewReader(os.Stdin)
-			text, err := reader.ReadString('\n')
-			if err != nil {
-				// No console input
-				var wg sync.WaitGroup
-				logger.Debug("No console available")
-				wg.Add(1)
-				wg.Wait()
-			}
+// Blocks until the program should exit. This is the case when SIGTERM or SIGINT was received
+// or all jobs were executed in the one shot mode
+func (s *NcConvertScheduler) Wait() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-			input := strings.Trim(strings.ToLower(text), "\n")
-			if input == "exit" {
-				break
-			} else if input == "execute" {
-				scheduler.scheduler.RunAll()
-			}
-		}
+	select {
+	case <-s.done:
+	case sig := <-signals:
+		logger.Info("Received %s → shutting down", sig)
 	}
+}
 
-	return &scheduler
+// Stops the scheduling of new executions and waits until the running jobs finished.
+// After two thirds of the time until the deadline the running jobs are canceled. The remaining time is
+// left for removing their incomplete uploads, so that the jobs stopped at the deadline.
+// The exit code of the program is returned
+func (s *NcConvertScheduler) Shutdown(deadline time.Time) int {
+	// No new runs can be started afterwards
+	s.mu.Lock()
+	s.env.Stop()
+	s.mu.Unlock()
+	defer s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		// Waits for all jobs executed by gocron
+		s.scheduler.Stop()
+		s.running.Wait()
+		close(done)
+	}()
+
+	timeout := time.Until(deadline)
+	grace := timeout * 2 / 3
+	select {
+	case <-done:
+		return 0
+	case <-time.After(grace):
+		logger.Warning("The running jobs did not finish within %s → canceling them", grace)
+		s.cancel()
+	}
+
+	// The canceled jobs remove their incomplete uploads
+	select {
+	case <-done:
+	case <-time.After(timeout - grace):
+		logger.Error("The canceled jobs did not stop in time")
+	}
+
+	return 1
 }
 
 // Executes all jobs and exits the program afterwards
-func (scheduler NcConvertScheduler) ScheduleExecutionsOneShot() {
+func (scheduler *NcConvertScheduler) ScheduleExecutionsOneShot() {
 	for _, user := range scheduler.users.Users {
 
 		// Schedule Nextcloud jobs
 		for _, job := range user.ConvertJobs {
-			convJob := NewNcJob(&job, &user)
-			convJob.ExecuteJob()
+			if scheduler.env.IsStopping() {
+				return
+			}
+			convJob, err := NewNcJob(&job, &user, scheduler.env)
+			if err != nil {
+				logger.Fatal("Failed to create office job '%s': %s", job.JobName, err)
+			}
+			if _, err := convJob.ExecuteJob(scheduler.ctx); err != nil {
+				logger.Error("%s", utils.FirstCharToUppercase(err.Error()))
+			}
 		}
 
 		// Schedule boockstack jobs
 		if user.BookStack.URL != "" {
 			for _, job := range user.BookStack.Jobs {
-				bsJob := NewBsJob(&job, &user)
-				bsJob.ExecuteJob()
+				if scheduler.env.IsStopping() {
+					return
+				}
+				bsJob, err := NewBsJob(&job, &user, scheduler.env)
+				if err != nil {
+					logger.Fatal("Failed to create BookStack job '%s': %s", job.JobName, err)
+				}
+				if _, err := bsJob.ExecuteJob(scheduler.ctx); err != nil {
+					logger.Error("%s", utils.FirstCharToUppercase(err.Error()))
+				}
 			}
 		}
 
@@ -82,33 +213,159 @@ func (scheduler NcConvertScheduler) ScheduleExecutionsOneShot() {
 }
 
 // Schedules all jobs with gocron
-func (s NcConvertScheduler) ScheduleExecutions() {
-	for ui, user := range s.users.Users {
+func (s *NcConvertScheduler) ScheduleExecutions() {
+	if err := s.Reload(s.users); err != nil {
+		logger.Fatal("%s", err)
+	}
+}
 
-		// Schedule Nextcloud jobs
-		for i, job := range user.ConvertJobs {
-			convJob := NewNcJob(&s.users.Users[ui].ConvertJobs[i], &s.users.Users[ui])
+// Applies the jobs of the given users. Only jobs that were added, removed or changed are
+// rescheduled. Running jobs are not interrupted, but a new run of a job is skipped while the old one is running.
+// If a job can't be created or scheduled, the current jobs are kept
+func (s *NcConvertScheduler) Reload(users *models.NcConvertUsers) error {
+	definitions := getJobDefinitions(users, s.env)
 
-			_, err := s.scheduler.Cron(job.Execution).DoWithJobDetails(s.executeJob, convJob)
-			if err != nil {
-				logger.Fatal("Failed to schedule office job '%s': %s", job.JobName, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Create all changed jobs first so that nothing is changed on an error
+	created := make(map[string]Job)
+	for key, definition := range definitions {
+		if existing, exists := s.jobs[key]; exists && existing.definition == definition.definition {
+			continue
+		}
+
+		job, err := definition.create()
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %s", definition.name, err)
+		}
+		created[key] = job
+	}
+
+	// Schedule the new jobs before the old ones are removed, so that they can be kept on an error
+	scheduled := make(map[string]*gocron.Job)
+	for key, job := range created {
+		definition := definitions[key]
+		cronJob, err := s.scheduler.Cron(definition.execution).Do(s.executeJob, key, job)
+		if err != nil {
+			for _, cronJob := range scheduled {
+				s.scheduler.RemoveByReference(cronJob)
 			}
+			return fmt.Errorf("failed to schedule %s: %s", definition.name, err)
 		}
+		scheduled[key] = cronJob
+	}
 
-		// Schedule boockstack jobs
-		if user.BookStack.URL != "" {
-			for i, job := range user.BookStack.Jobs {
-				bsJob := NewBsJob(&s.users.Users[ui].BookStack.Jobs[i], &s.users.Users[ui])
+	for key, existing := range s.jobs {
+		_, exists := definitions[key]
+		if _, changed := created[key]; exists && !changed {
+			continue
+		}
 
-				_, err := s.scheduler.Cron(job.Execution).DoWithJobDetails(s.executeJob, bsJob)
-				if err != nil {
-					logger.Fatal("Failed to schedule BookStack job '%s': %s", job.JobName, err)
+		s.scheduler.RemoveByReference(existing.cronJob)
+		delete(s.jobs, key)
+		if !exists {
+			logger.Info("Removed %s", existing.name)
+		}
+	}
+
+	for key, job := range created {
+		definition := definitions[key]
+		cronJob := scheduled[key]
+		s.jobs[key] = &scheduledJob{
+			id:         getJobID(key),
+			name:       definition.name,
+			jobType:    definition.jobType,
+			jobName:    definition.jobName,
+			user:       definition.user,
+			execution:  definition.execution,
+			job:        job,
+			definition: definition.definition,
+			cronJob:    cronJob,
+		}
+		logger.Info("Scheduled %s (%s)", definition.name, definition.execution)
+	}
+	s.users = users
+
+	return nil
+}
+
+// Returns the definitions of all jobs indexed by their key
+func getJobDefinitions(users *models.NcConvertUsers, env *Environment) map[string]jobDefinition {
+	rtc := make(map[string]jobDefinition)
+
+	for ui := range users.Users {
+		user := &users.Users[ui]
+
+		// Nextcloud jobs
+		for i := range user.ConvertJobs {
+			job := &user.ConvertJobs[i]
+			rtc[getJobKey("office", user, job.JobName)] = jobDefinition{
+				name:       fmt.Sprintf("office job '%s'", job.JobName),
+				jobType:    "office",
+				jobName:    job.JobName,
+				user:       getJobUser(user),
+				execution:  job.Execution,
+				definition: getJobDefinition(user, job),
+				create: func() (Job, error) {
+					convJob, err := NewNcJob(job, user, env)
+					if err != nil {
+						return nil, err
+					}
+					return convJob, nil
+				},
+			}
+		}
+
+		// Boockstack jobs
+		if user.BookStack.URL != "" {
+			for i := range user.BookStack.Jobs {
+				job := &user.BookStack.Jobs[i]
+				rtc[getJobKey("bookstack", user, job.JobName)] = jobDefinition{
+					name:       fmt.Sprintf("BookStack job '%s'", job.JobName),
+					jobType:    "bookstack",
+					jobName:    job.JobName,
+					user:       getJobUser(user),
+					execution:  job.Execution,
+					definition: getJobDefinition(user, job),
+					create: func() (Job, error) {
+						bsJob, err := NewBsJob(job, user, env)
+						if err != nil {
+							return nil, err
+						}
+						return bsJob, nil
+					},
 				}
 			}
 		}
 	}
+
+	return rtc
 }
 
-func (s NcConvertScheduler) executeJob(job Job, scheduledJob gocron.Job) {
-	job.ExecuteJob()
+// Returns the JSON of the job and the settings of its user
+func getJobDefinition(user *models.NextcloudUser, job interface{}) string {
+	settings := *user
+	settings.ConvertJobs = nil
+	settings.BookStack.Jobs = nil
+
+	rtc, _ := json.Marshal(struct {
+		User models.NextcloudUser
+		Job  interface{}
+	}{settings, job})
+
+	return string(rtc)
+}
+
+// Executes the job by the schedule. The execution is skipped if the job is still running
+func (s *NcConvertScheduler) executeJob(key string, job Job) {
+	run, ctx, err := s.startRun(key, "schedule")
+	if errors.Is(err, ErrJobRunning) {
+		logger.Info("Skipping the execution of job %s because it's still running", key)
+		return
+	} else if err != nil {
+		return
+	}
+
+	s.execute(key, run, job, ctx)
 }
@@ -0,0 +1,102 @@
+package ncworker
+
+import (
+	"context"
+	"strings"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/internal/storage"
+)
+
+// Applies the deletion policy to the files without a source.
+// The total count of files inside the destination directory is used to check the
+// configured threshold. Returns the files that should be deleted or archived and
+// if the deletion was aborted because of the threshold
+func getFilesToDelete(jobName string, deletion *models.Deletion, files []storage.File, total int) ([]storage.File, bool) {
+	if len(files) == 0 {
+		return files, false
+	}
+
+	if deletion.Policy == models.KeepDeletion {
+		logger.Debug("Keeping %d files without a source of job \"%s\"", len(files), jobName)
+		return nil, false
+	}
+
+	if deletion.MaxPercent > 0 && len(files)*100 > deletion.MaxPercent*total {
+		logger.Warning(
+			"Skipping the deletion of job \"%s\": %d of %d files would be removed (more than %d%%)",
+			jobName, len(files), total, deletion.MaxPercent,
+		)
+		return nil, true
+	}
+
+	return files, false
+}
+
+// Returns the IDs of the sources that are not available anymore and whose converted file is not deleted
+// (e.g. with the policy "keep" or because the file does not exist).
+// The IDs of the sources whose converted file is deleted are returned separately indexed by the path of the file.
+// They may only be forgotten when the file was deleted. If the deletion was aborted, nothing is forgotten
+func getForgottenSources(entries map[string]state.Entry, available map[string]bool, deleted []storage.File, aborted bool) ([]string, map[string][]string) {
+	forgotten := []string{}
+	orphanedSources := make(map[string][]string)
+	if aborted {
+		return forgotten, orphanedSources
+	}
+
+	deletedPaths := make(map[string]bool, len(deleted))
+	for _, file := range deleted {
+		deletedPaths[file.Path] = true
+	}
+
+	for id, entry := range entries {
+		if available[id] {
+			continue
+		}
+
+		if deletedPaths[entry.Destination] {
+			orphanedSources[entry.Destination] = append(orphanedSources[entry.Destination], id)
+		} else {
+			forgotten = append(forgotten, id)
+		}
+	}
+
+	return forgotten, orphanedSources
+}
+
+// Deletes the file or moves it into the archive directory when the policy "trash" is used
+func removeFile(ctx context.Context, s storage.Storage, deletion *models.Deletion, destinationDir string, file storage.File) error {
+	if deletion.Policy != models.TrashDeletion {
+		return s.Delete(ctx, file.Path)
+	}
+
+	archivePath := getArchivePath(deletion, destinationDir, file.Path)
+	if err := s.Mkdir(ctx, archivePath[0:strings.LastIndex(archivePath, "/")+1]); err != nil {
+		return err
+	}
+	return s.Move(ctx, file.Path, archivePath)
+}
+
+// Returns the path inside the archive directory for a file of the destination directory.
+// The folder structure relative to the destination directory is kept
+func getArchivePath(deletion *models.Deletion, destinationDir string, path string) string {
+	archiveDir := deletion.ArchiveDir
+	if !strings.HasSuffix(archiveDir, "/") {
+		archiveDir += "/"
+	}
+
+	return archiveDir + strings.TrimPrefix(path, destinationDir)
+}
+
+// Adds the files to delete to the public plan
+func addDeletionsToPlan(plan *Plan, deletion *models.Deletion, destinationDir string, files []storage.File) {
+	for _, file := range files {
+		if deletion.Policy == models.TrashDeletion {
+			plan.Archives = append(plan.Archives, PlanMove{From: file.Path, To: getArchivePath(deletion, destinationDir, file.Path)})
+		} else {
+			plan.Deletions = append(plan.Deletions, file.Path)
+		}
+	}
+}
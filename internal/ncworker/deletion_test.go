@@ -0,0 +1,178 @@
+package ncworker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/internal/storage"
+)
+
+// Returns the given count of files inside the directory "pdf/"
+func getTestFiles(count int) []storage.File {
+	rtc := make([]storage.File, count)
+	for i := range rtc {
+		rtc[i] = storage.File{Path: "pdf/" + strings.Repeat("a", i+1) + ".pdf"}
+	}
+
+	return rtc
+}
+
+func TestGetFilesToDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		deletion models.Deletion
+		orphaned int
+		total    int
+		want     int
+		// If the deletion is expected to be aborted
+		wantAborted bool
+	}{
+		{name: "mirror", deletion: models.Deletion{Policy: models.MirrorDeletion}, orphaned: 3, total: 4, want: 3},
+		{name: "default policy", orphaned: 1, total: 1, want: 1},
+		{name: "trash", deletion: models.Deletion{Policy: models.TrashDeletion, ArchiveDir: "archive/"}, orphaned: 2, total: 4, want: 2},
+		{name: "keep", deletion: models.Deletion{Policy: models.KeepDeletion}, orphaned: 3, total: 4},
+		{name: "nothing to delete", deletion: models.Deletion{MaxPercent: 10}, total: 4},
+		{name: "below the threshold", deletion: models.Deletion{MaxPercent: 50}, orphaned: 1, total: 4, want: 1},
+		{name: "exactly the threshold", deletion: models.Deletion{MaxPercent: 50}, orphaned: 2, total: 4, want: 2},
+		{name: "above the threshold", deletion: models.Deletion{MaxPercent: 50}, orphaned: 3, total: 4, wantAborted: true},
+		{name: "all files", deletion: models.Deletion{MaxPercent: 99}, orphaned: 4, total: 4, wantAborted: true},
+		{name: "threshold with trash", deletion: models.Deletion{Policy: models.TrashDeletion, ArchiveDir: "archive/", MaxPercent: 20},
+			orphaned: 1, total: 4, wantAborted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, aborted := getFilesToDelete("office", &tt.deletion, getTestFiles(tt.orphaned), tt.total)
+			if len(got) != tt.want || aborted != tt.wantAborted {
+				t.Errorf("got %d files (aborted: %t), want %d files (aborted: %t)", len(got), aborted, tt.want, tt.wantAborted)
+			}
+		})
+	}
+}
+
+func TestGetForgottenSources(t *testing.T) {
+	entries := map[string]state.Entry{
+		"1": {SourceID: "1", Destination: "pdf/a.pdf"},
+		"2": {SourceID: "2", Destination: "pdf/aa.pdf"},
+		"3": {SourceID: "3", Destination: "pdf/aaa.pdf"},
+		// Another source that was converted to the same file
+		"4": {SourceID: "4", Destination: "pdf/aa.pdf"},
+	}
+
+	tests := []struct {
+		name      string
+		available map[string]bool
+		deleted   []storage.File
+		aborted   bool
+		// Expected forgotten sources and the orphaned sources with their deleted file ("path=id")
+		wantForgotten []string
+		wantOrphaned  []string
+	}{
+		{
+			name:      "all sources available",
+			available: map[string]bool{"1": true, "2": true, "3": true, "4": true},
+		},
+		{
+			name:          "converted file does not exist",
+			available:     map[string]bool{"1": true, "2": true, "4": true},
+			wantForgotten: []string{"3"},
+		},
+		{
+			name:          "converted file is deleted",
+			available:     map[string]bool{"1": true},
+			deleted:       getTestFiles(2)[1:],
+			wantForgotten: []string{"3"},
+			wantOrphaned:  []string{"pdf/aa.pdf=2", "pdf/aa.pdf=4"},
+		},
+		{
+			name:          "converted files are kept",
+			available:     map[string]bool{"1": true},
+			wantForgotten: []string{"2", "3", "4"},
+		},
+		{
+			name:    "aborted deletion",
+			aborted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forgotten, orphanedSources := getForgottenSources(entries, tt.available, tt.deleted, tt.aborted)
+
+			orphaned := []string{}
+			for path, ids := range orphanedSources {
+				for _, id := range ids {
+					orphaned = append(orphaned, path+"="+id)
+				}
+			}
+			sort.Strings(forgotten)
+			sort.Strings(orphaned)
+
+			if strings.Join(forgotten, ",") != strings.Join(tt.wantForgotten, ",") {
+				t.Errorf("forgotten: got %v, want %v", forgotten, tt.wantForgotten)
+			}
+			if strings.Join(orphaned, ",") != strings.Join(tt.wantOrphaned, ",") {
+				t.Errorf("orphaned: got %v, want %v", orphaned, tt.wantOrphaned)
+			}
+		})
+	}
+}
+
+func TestApplyDeletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		deletion models.Deletion
+		// Expected count of files and state entries after the execution
+		wantFiles   int
+		wantEntries int
+	}{
+		{name: "mirror"},
+		{name: "above the threshold", deletion: models.Deletion{MaxPercent: 50}, wantFiles: 3, wantEntries: 3},
+		{name: "keep", deletion: models.Deletion{Policy: models.KeepDeletion}, wantFiles: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The search (transiently) returns no documents
+			job := newTestConvertJob(t, models.NcConvertJob{Deletion: tt.deletion})
+			job.client = newTestSearchClient(t, []nextcloud.NcFile{})
+
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "pdf"), 0755); err != nil {
+				t.Fatalf("failed to create the destination directory: %s", err)
+			}
+			for i, file := range getTestFiles(3) {
+				if err := os.WriteFile(filepath.Join(dir, file.Path), []byte(file.Path), 0644); err != nil {
+					t.Fatalf("failed to create the file: %s", err)
+				}
+				source := newTestSource("docs/"+strings.TrimPrefix(file.Path, "pdf/"), i+1)
+				job.env.State.Set(job.key, job.getStateEntry(&source, file.Path, time.Now()))
+			}
+			var err error
+			if job.storage, err = storage.NewLocal(dir); err != nil {
+				t.Fatalf("failed to create the storage: %s", err)
+			}
+
+			plan, err := job.plan(context.Background())
+			if err != nil {
+				t.Fatalf("failed to plan the job: %s", err)
+			}
+			job.apply(context.Background(), plan)
+
+			if files := listFiles(t, dir); len(files) != tt.wantFiles {
+				t.Errorf("expected %d files, got %v", tt.wantFiles, files)
+			}
+			if entries := job.env.State.Entries(job.key); len(entries) != tt.wantEntries {
+				t.Errorf("expected %d sources in the state, got %d", tt.wantEntries, len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package ncworker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Time the result of the readiness checks is reused
+const readinessCacheTime = 30 * time.Second
+
+// Maximum time of a single dependency check
+const dependencyTimeout = 5 * time.Second
+
+// Result of the check of a service the jobs depend on
+type DependencyStatus struct {
+	// Type of the service (nextcloud, bookstack or converter)
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+	// Duration of the check in milliseconds
+	Duration int64 `json:"durationMs"`
+}
+
+// Result of all readiness checks
+type Readiness struct {
+	Ready        bool               `json:"ready"`
+	CheckedAt    time.Time          `json:"checkedAt"`
+	Dependencies []DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// A service that has to be checked
+type dependency struct {
+	status DependencyStatus
+	check  func(ctx context.Context) error
+}
+
+// The last readiness checks
+type readinessCache struct {
+	mu     sync.Mutex
+	result *Readiness
+}
+
+// Returns an error if the scheduler does not work anymore
+func (s *NcConvertScheduler) Health() error {
+	if !s.env.IsStopping() && !s.config.Server.OneShot && !s.scheduler.IsRunning() {
+		return fmt.Errorf("the scheduler is not running")
+	}
+
+	// A dead lock would block all executions
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("the scheduler does not respond")
+	}
+}
+
+// Checks if Nextcloud, BookStack and the converters of all users are available.
+// The result is cached for 30 seconds
+func (s *NcConvertScheduler) Readiness(ctx context.Context) Readiness {
+	if s.env.IsStopping() {
+		return Readiness{CheckedAt: time.Now(), Dependencies: []DependencyStatus{}}
+	}
+
+	s.readiness.mu.Lock()
+	defer s.readiness.mu.Unlock()
+	if s.readiness.result != nil && time.Since(s.readiness.result.CheckedAt) < readinessCacheTime {
+		return *s.readiness.result
+	}
+
+	dependencies := s.getDependencies()
+	var wg sync.WaitGroup
+	wg.Add(len(dependencies))
+	for i := range dependencies {
+		go func(dep *dependency) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := dep.check(checkCtx)
+			dep.status.Duration = time.Since(start).Milliseconds()
+			dep.status.Ready = err == nil
+			if err != nil {
+				dep.status.Error = err.Error()
+			}
+		}(&dependencies[i])
+	}
+	wg.Wait()
+
+	rtc := Readiness{Ready: true, CheckedAt: time.Now(), Dependencies: make([]DependencyStatus, len(dependencies))}
+	for i, dep := range dependencies {
+		rtc.Dependencies[i] = dep.status
+		rtc.Ready = rtc.Ready && dep.status.Ready
+	}
+
+	// A canceled request should not be cached
+	if ctx.Err() == nil {
+		s.readiness.result = &rtc
+	}
+	return rtc
+}
+
+// Returns the services of all users and jobs. Every service is only contained once
+func (s *NcConvertScheduler) getDependencies() []dependency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rtc := make(map[string]dependency)
+	for i := range s.users.Users {
+		user := &s.users.Users[i]
+
+		// The check should fail fast instead of retrying
+		checkUser := *user
+		checkUser.Client.Retries = -1
+		client := nextcloud.NewClient(&checkUser)
+		rtc["nextcloud:"+getJobUser(user)] = dependency{
+			status: DependencyStatus{Type: "nextcloud", Name: getJobUser(user)},
+			check: func(ctx context.Context) error {
+				_, err := client.GetFileInfo(ctx, "")
+				return err
+			},
+		}
+
+		if user.BookStack.URL != "" {
+			bookStack := &user.BookStack
+			rtc["bookstack:"+bookStack.URL+":"+string(bookStack.Token)] = dependency{
+				status: DependencyStatus{Type: "bookstack", Name: bookStack.URL},
+				check: func(ctx context.Context) error {
+					return checkBookStack(ctx, bookStack)
+				},
+			}
+		}
+	}
+
+	// The converters are taken from the jobs because they can override the converter of the user
+	for _, job := range s.jobs {
+		if convJob, isOffice := job.job.(*convertJob); isOffice {
+			rtc["converter:"+convJob.converterName] = dependency{
+				status: DependencyStatus{Type: "converter", Name: convJob.converterName},
+				check:  convJob.converter.Check,
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(rtc))
+	for key := range rtc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dependencies := make([]dependency, len(keys))
+	for i, key := range keys {
+		dependencies[i] = rtc[key]
+	}
+	return dependencies
+}
+
+// Checks if the API token of BookStack is valid
+func checkBookStack(ctx context.Context, bookStack *models.BookStack) error {
+	client := http.Client{Timeout: dependencyTimeout, Transport: bookStackTransport}
+	req := newBookStackRequest(bookStack, http.MethodGet, "books?count=1", nil).WithContext(ctx)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		return nil
+	case 401, 403:
+		return fmt.Errorf("the API token is invalid or has no access to the API (#%d)", res.StatusCode)
+	default:
+		return fmt.Errorf("expected status code 200, got %d", res.StatusCode)
+	}
+}
@@ -1,5 +1,73 @@
 package ncworker
 
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+)
+
 type Job interface {
-	ExecuteJob()
+	// Executes the job. When the context is canceled, all running requests are aborted
+	ExecuteJob(ctx context.Context) (*RunResult, error)
+}
+
+// Dependencies that are shared between all jobs
+type Environment struct {
+	Config *models.WebConfig
+	// Persistent state of the converted documents
+	State *state.Store
+
+	// Closed when the program is shutting down
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func NewEnvironment(config *models.WebConfig, store *state.Store) *Environment {
+	return &Environment{
+		Config:   config,
+		State:    store,
+		stopping: make(chan struct{}),
+	}
+}
+
+// Signals all jobs that the program is shutting down. Running jobs finish the
+// files they are currently converting but don't start new ones
+func (e *Environment) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stopping)
+	})
+}
+
+// Returns true if the program is shutting down
+func (e *Environment) IsStopping() bool {
+	select {
+	case <-e.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the client to access the given storage. For all types except "webdav"
+// the nextcloud of the user is used
+func getClient(config models.Storage, ncUser *models.NextcloudUser) (*nextcloud.Client, error) {
+	if config.Type == models.WebDAVStorage {
+		return nextcloud.NewWebDAVClient(config, ncUser.Client)
+	}
+
+	return nextcloud.NewClient(ncUser), nil
+}
+
+// Returns a unique key of a job which is used to save its state
+func getJobKey(jobType string, ncUser *models.NextcloudUser, jobName string) string {
+	return fmt.Sprintf("%s:%s@%s:%s", jobType, ncUser.Username, ncUser.NextcloudBaseUrl, jobName)
+}
+
+// Returns the user of a job for displaying (myUser@https://cloud.myDomain.de)
+func getJobUser(ncUser *models.NextcloudUser) string {
+	return ncUser.Username + "@" + ncUser.NextcloudBaseUrl
 }
@@ -1,131 +1,414 @@
 package ncworker
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/converter"
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
 	"git.rpjosh.de/ncDocConverter/internal/models"
 	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/internal/storage"
 	"git.rpjosh.de/ncDocConverter/pkg/utils"
 )
 
 type convertJob struct {
 	job    *models.NcConvertJob
 	ncUser *models.NextcloudUser
+	env    *Environment
+
+	// Client to access the source documents
+	client *nextcloud.Client
+	// Destination to save the converted files in
+	storage storage.Storage
+	// Service to convert the documents with
+	converter     converter.Converter
+	converterName string
+	// Content types of the documents to convert
+	sourceTypes []string
+	// Format to convert the documents to
+	format models.Format
+
+	// Key of the job inside the state
+	key string
 }
 
 type convertQueu struct {
 	source      nextcloud.NcFile
 	destination string
+	reason      string
 }
 
-func NewNcJob(job *models.NcConvertJob, ncUser *models.NextcloudUser) *convertJob {
+// A converted file that has to be moved because its source was renamed or moved
+type moveQueu struct {
+	source nextcloud.NcFile
+	from   string
+	to     string
+}
+
+// All actions to perform in an execution of the job
+type officePlan struct {
+	filesToConvert []convertQueu
+	filesToMove    []moveQueu
+	filesToDelete  []storage.File
+	directorys     []string
+
+	// Up to date files whose source is not contained in the state yet
+	adopted []state.Entry
+	// IDs of the sources that are not available anymore and whose converted file is not deleted
+	forgotten []string
+	// IDs of the sources that are not available anymore indexed by the path of their converted file
+	// that is deleted. They are forgotten when the file was deleted
+	orphanedSources map[string][]string
+}
+
+func NewNcJob(job *models.NcConvertJob, ncUser *models.NextcloudUser, env *Environment) (*convertJob, error) {
+	if job.Source.Type == models.LocalStorage {
+		return nil, fmt.Errorf("the local storage is not supported as a source")
+	}
+	client, err := getClient(job.Source, ncUser)
+	if err != nil {
+		return nil, err
+	}
+	destinationClient, err := getClient(job.Destination, ncUser)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := storage.New(job.Destination, destinationClient, getJobKey("office", ncUser, job.JobName))
+	if err != nil {
+		return nil, err
+	}
+	// The converter of the job takes precedence over the one of the user
+	converterConfig := ncUser.Converter
+	if job.Converter.Type != "" {
+		converterConfig = job.Converter
+	}
+	converterName := converter.GetName(converterConfig, client)
+	converter, err := converter.New(converterConfig, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := job.Deletion.Validate(job.DestinationDir); err != nil {
+		return nil, err
+	}
+	format := job.Format.Normalize()
+	if !format.IsOfficeFormat() {
+		return nil, fmt.Errorf("invalid format given: '%s'. Expected one of %v", job.Format, models.OfficeFormats)
+	}
+	sourceTypes, err := getSourceContentTypes(job.SourceTypes, format)
+	if err != nil {
+		return nil, err
+	}
+
 	convJob := &convertJob{
-		job:    job,
-		ncUser: ncUser,
+		job:         job,
+		ncUser:      ncUser,
+		env:         env,
+		client:      client,
+		storage:     storage,
+		converter:   converter,
+		sourceTypes: sourceTypes,
+		format:      format,
+		key:         getJobKey("office", ncUser, job.JobName),
+
+		converterName: converterName,
 	}
 
-	return convJob
+	return convJob, nil
 }
 
-func (job *convertJob) ExecuteJob() {
+func (job *convertJob) ExecuteJob(ctx context.Context) (*RunResult, error) {
+	plan, err := job.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if job.env.IsStopping() {
+		return &RunResult{}, nil
+	}
 
+	if job.job.DryRun || job.env.Config.Server.DryRun {
+		publicPlan := job.getPlan(plan)
+		publicPlan.report(job.env.Config.Server.DataDir)
+		return &RunResult{Plan: publicPlan}, nil
+	}
+
+	return job.apply(ctx, plan), nil
+}
+
+// Determines which files have to be converted, moved and deleted.
+// No files are modified
+func (job *convertJob) plan(ctx context.Context) (*officePlan, error) {
 	// Get existing directory contents
-	sourceFolder, err := nextcloud.SearchInDirectory(
-		job.ncUser,
-		job.job.SourceDir,
-		[]string{
-			"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
-			"application/msword",
-		},
-	)
+	sourceFolder, err := job.client.SearchInDirectory(ctx, job.job.SourceDir, job.sourceTypes, bool(job.job.Recursive))
 	if err != nil {
-		logger.Error("Failed to get files in source directory '%s': %s", job.job.SourceDir, err)
-		return
+		return nil, fmt.Errorf("failed to get files in source directory '%s': %s", job.job.SourceDir, err)
 	}
 
-	destinationFolder, err := nextcloud.SearchInDirectory(
-		job.ncUser,
+	destinationMap, err := job.storage.List(
+		ctx,
 		job.job.DestinationDir,
 		[]string{
-			"application/pdf",
+			job.format.ContentType(),
 		},
 	)
-	if err != nil {
-		logger.Error("Failed to get files in destination directory '%s': %s", job.job.DestinationDir, err)
-		return
+	if errors.Is(err, storage.ErrNotExist) {
+		destinationMap = make(map[string]storage.File)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get files in destination directory '%s': %s", job.job.DestinationDir, err)
 	}
+	destinationCount := len(destinationMap)
 
 	// Store all files in a map
-	prefix := "/remote.php/dav/files/" + job.ncUser.Username + "/"
-	sourceMap := nextcloud.ParseSearchResult(sourceFolder, prefix, job.job.SourceDir)
-	destinationMap := nextcloud.ParseSearchResult(destinationFolder, prefix, job.job.DestinationDir)
+	sourceMap := job.getSourceMap(nextcloud.ParseSearchResultFiles(sourceFolder, job.client.HrefPrefix()))
+	sourceIDs := make(map[string]bool, len(sourceMap))
+	for _, source := range sourceMap {
+		sourceIDs[source.ID()] = true
+	}
 
-	// check which files should be converted
-	var filesToConvert []convertQueu
-	var directorys []string
+	plan := &officePlan{}
+
+	// Move the converted files of renamed or moved sources instead of converting them again
+	plan.filesToMove = job.getFilesToMove(sourceMap, destinationMap)
+	moved := make(map[string]bool, len(plan.filesToMove))
+	for _, move := range plan.filesToMove {
+		moved[move.to] = true
+		appendIfNotExists(&plan.directorys, move.to[0:strings.LastIndex(move.to, "/")+1])
+
+		// The content could also have been changed
+		if entry, _ := job.env.State.Get(job.key, move.source.ID()); entry.ETag != move.source.ETag || entry.Size != move.source.Size {
+			plan.filesToConvert = append(plan.filesToConvert, convertQueu{source: move.source, destination: move.to, reason: reasonModified})
+		}
+	}
 
+	// check which files should be converted
 	for index, source := range sourceMap {
+		if moved[job.getDestinationDir(index)] {
+			continue
+		}
+
 		// Check if the file exists in the destination map
 		if dest, exists := destinationMap[index]; exists {
-			// Compare timestamp and size
-			if dest.LastModified.Before(source.LastModified) {
-				filesToConvert = append(filesToConvert, convertQueu{source: source, destination: dest.Path})
+			if !job.isUpToDate(&source, &dest) {
+				plan.filesToConvert = append(plan.filesToConvert, convertQueu{source: source, destination: dest.Path, reason: reasonModified})
+			} else if _, known := job.env.State.Get(job.key, source.ID()); !known {
+				plan.adopted = append(plan.adopted, job.getStateEntry(&source, dest.Path, dest.LastModified))
 			}
 			delete(destinationMap, index)
 		} else {
 			// the directory could not be existing -> check for existance
-			destinationDir := job.getDestinationDir(source.Path)
-			appendIfNotExists(&directorys, destinationDir[0:strings.LastIndex(destinationDir, "/")+1])
+			destinationDir := job.getDestinationDir(index)
+			appendIfNotExists(&plan.directorys, destinationDir[0:strings.LastIndex(destinationDir, "/")+1])
 
-			filesToConvert = append(filesToConvert, convertQueu{source: source, destination: destinationDir})
+			plan.filesToConvert = append(plan.filesToConvert, convertQueu{source: source, destination: destinationDir, reason: reasonNew})
 
 			delete(destinationMap, index)
 		}
 	}
 
-	var wg sync.WaitGroup
-
 	// Delete the files which are not available anymore
-	wg.Add(len(destinationMap))
+	orphaned := make([]storage.File, 0, len(destinationMap))
 	for _, dest := range destinationMap {
-		go func(file *nextcloud.NcFile) {
-			err := nextcloud.DeleteFile(job.ncUser, file.Path)
-			if err != nil {
-				logger.Error(utils.FirstCharToUppercase(err.Error()))
-			}
-			wg.Done()
-		}(&dest)
+		orphaned = append(orphaned, dest)
 	}
-	wg.Wait()
+	var aborted bool
+	plan.filesToDelete, aborted = getFilesToDelete(job.job.JobName, &job.job.Deletion, orphaned, destinationCount)
+	plan.forgotten, plan.orphanedSources = getForgottenSources(job.env.State.Entries(job.key), sourceIDs, plan.filesToDelete, aborted)
+
+	return plan, nil
+}
+
+// Executes the actions of the plan
+func (job *convertJob) apply(ctx context.Context, plan *officePlan) *RunResult {
+	var wg sync.WaitGroup
+	result := &RunResult{}
 
 	// Create required directorys
-	wg.Add(len(directorys))
-	for _, dest := range directorys {
+	wg.Add(len(plan.directorys))
+	for _, dest := range plan.directorys {
 		go func(path string) {
-			nextcloud.CreateFoldersRecursively(job.ncUser, path)
+			if err := job.storage.Mkdir(ctx, path); err != nil {
+				logger.Error("Failed to create directory '%s': %s", path, err)
+			}
 			wg.Done()
 		}(dest)
 	}
 	wg.Wait()
 
+	// Move the files of renamed sources
+	wg.Add(len(plan.filesToMove))
+	for _, move := range plan.filesToMove {
+		go func(move moveQueu) {
+			defer wg.Done()
+			if job.env.IsStopping() {
+				return
+			}
+			if err := job.storage.Move(ctx, move.from, move.to); err != nil {
+				logger.Error(utils.FirstCharToUppercase(err.Error()))
+				atomic.AddInt32(&result.Failed, 1)
+				metrics.DocumentsFailed.WithLabelValues(job.getMetricLabels(true)...).Inc()
+				return
+			}
+			atomic.AddInt32(&result.Moved, 1)
+
+			id := move.source.ID()
+			entry, _ := job.env.State.Get(job.key, id)
+			entry.SourcePath = move.source.Path
+			entry.Destination = move.to
+			job.env.State.Set(job.key, entry)
+		}(move)
+	}
+	wg.Wait()
+
+	// Delete the files which are not available anymore
+	wg.Add(len(plan.filesToDelete))
+	for _, dest := range plan.filesToDelete {
+		go func(file storage.File) {
+			defer wg.Done()
+			if job.env.IsStopping() {
+				return
+			}
+			err := removeFile(ctx, job.storage, &job.job.Deletion, job.job.DestinationDir, file)
+			if err != nil {
+				logger.Error(utils.FirstCharToUppercase(err.Error()))
+				atomic.AddInt32(&result.Failed, 1)
+				metrics.DocumentsFailed.WithLabelValues(job.getMetricLabels(true)...).Inc()
+				return
+			}
+			atomic.AddInt32(&result.Deleted, 1)
+			metrics.DocumentsDeleted.WithLabelValues(job.getMetricLabels(false)...).Inc()
+
+			for _, id := range plan.orphanedSources[file.Path] {
+				job.env.State.Delete(job.key, id)
+			}
+		}(dest)
+	}
+	wg.Wait()
+
 	// Convert the files
-	wg.Add(len(filesToConvert))
-	for _, file := range filesToConvert {
+	wg.Add(len(plan.filesToConvert))
+	for _, file := range plan.filesToConvert {
 		go func(cvt convertQueu) {
-			job.convertFile(cvt.source.Path, cvt.source.Fileid, cvt.destination)
-			wg.Done()
+			defer wg.Done()
+			if job.env.IsStopping() {
+				return
+			}
+			if err := job.convertFile(ctx, &cvt.source, cvt.destination); err != nil {
+				logger.Error("%s", utils.FirstCharToUppercase(err.Error()))
+				atomic.AddInt32(&result.Failed, 1)
+				metrics.DocumentsFailed.WithLabelValues(job.getMetricLabels(true)...).Inc()
+				return
+			}
+			atomic.AddInt32(&result.Converted, 1)
+			metrics.DocumentsConverted.WithLabelValues(job.getMetricLabels(true)...).Inc()
+
+			job.env.State.Set(job.key, job.getStateEntry(&cvt.source, cvt.destination, time.Now()))
 		}(file)
 	}
 	wg.Wait()
 
-	logger.Info("Finished Nextcloud job \"%s\": %d documents converted", job.job.JobName, len(filesToConvert))
+	// Update the state
+	for _, entry := range plan.adopted {
+		job.env.State.Set(job.key, entry)
+	}
+	for _, id := range plan.forgotten {
+		job.env.State.Delete(job.key, id)
+	}
+	if err := job.env.State.Save(); err != nil {
+		logger.Error("Failed to save the state of job \"%s\": %s", job.job.JobName, err)
+	}
+
+	logger.Info("Finished Nextcloud job \"%s\": %d documents converted, %d moved", job.job.JobName, result.Converted, result.Moved)
+	return result
+}
+
+// Returns the public representation of the plan
+func (job *convertJob) getPlan(plan *officePlan) *Plan {
+	rtc := newPlan("office", job.job.JobName, job.ncUser.Username)
+
+	rtc.Directories = append(rtc.Directories, plan.directorys...)
+	for _, move := range plan.filesToMove {
+		rtc.Moves = append(rtc.Moves, PlanMove{From: move.from, To: move.to})
+	}
+	for _, cvt := range plan.filesToConvert {
+		rtc.Conversions = append(rtc.Conversions, PlanConversion{Source: cvt.source.Path, Destination: cvt.destination, Reason: cvt.reason})
+	}
+	addDeletionsToPlan(rtc, &job.job.Deletion, job.job.DestinationDir, plan.filesToDelete)
+	rtc.sort()
+
+	return rtc
+}
+
+// Returns the converted files whose source was renamed or moved since the last conversion.
+// The source is identified by its file ID. The moved files are removed from the destination map
+func (job *convertJob) getFilesToMove(sourceMap map[string]nextcloud.NcFile, destinationMap map[string]storage.File) []moveQueu {
+	destinationIndex := make(map[string]string, len(destinationMap))
+	for index, dest := range destinationMap {
+		destinationIndex[dest.Path] = index
+	}
+
+	var rtc []moveQueu
+	for index, source := range sourceMap {
+		entry, exists := job.env.State.Get(job.key, source.ID())
+		destination := job.getDestinationDir(index)
+		if !exists || entry.Destination == destination {
+			continue
+		}
+
+		// The destination is already occupied → it will be converted again
+		if _, occupied := destinationMap[index]; occupied {
+			continue
+		}
+
+		// The previously converted file has to exist and must not be used by another source
+		oldIndex, found := destinationIndex[entry.Destination]
+		if !found {
+			continue
+		}
+		if _, used := sourceMap[oldIndex]; used {
+			continue
+		}
+
+		logger.Debug("Source %s was moved → moving %s to %s", source.Path, entry.Destination, destination)
+		rtc = append(rtc, moveQueu{source: source, from: entry.Destination, to: destination})
+		delete(destinationMap, oldIndex)
+		delete(destinationIndex, entry.Destination)
+	}
+
+	return rtc
+}
+
+// Returns true if the destination file was converted from the current version of the source.
+// When the source is unknown (e.g. converted by an older version), the modification
+// time is compared
+func (job *convertJob) isUpToDate(source *nextcloud.NcFile, destination *storage.File) bool {
+	entry, exists := job.env.State.Get(job.key, source.ID())
+	if exists {
+		return entry.ETag == source.ETag && entry.Size == source.Size && entry.Destination == destination.Path
+	}
+
+	return !destination.LastModified.Before(source.LastModified)
+}
+
+// Returns the entry of the state for the converted source
+func (job *convertJob) getStateEntry(source *nextcloud.NcFile, destination string, convertedAt time.Time) state.Entry {
+	return state.Entry{
+		SourceID:    source.ID(),
+		SourcePath:  source.Path,
+		ETag:        source.ETag,
+		Size:        source.Size,
+		Destination: destination,
+		ConvertedAt: convertedAt,
+	}
 }
 
 // Appends the directory to the array if it isn't contained
@@ -138,53 +421,118 @@ func appendIfNotExists(dirs *[]string, directory string) {
 		// the existing directory is already referenced in the current
 		if directoryLength > currentLength && directory[0:currentLength] == currentDir {
 			(*dirs)[i] = directory
-			continue
+			return
 		} else if directoryLength <= currentLength && currentDir[0:directoryLength] == directory {
-			continue
+			return
 		}
 	}
 	*dirs = append(*dirs, directory)
 }
 
-func (job *convertJob) getDestinationDir(sourceFile string) string {
-	sourceFile = sourceFile[len(job.job.SourceDir):]
-	var extension = filepath.Ext(sourceFile)
-	var name = sourceFile[0 : len(sourceFile)-len(extension)]
+// Returns the destination path for the given name of the source map
+func (job *convertJob) getDestinationDir(name string) string {
+	return job.job.DestinationDir + name + "." + job.format.Extension()
+}
+
+// Indexes the source files by the relative path to the source directory without
+// the file extension ("folder/report"). If the folders should not be kept, only the
+// file name is used ("report").
+//
+// Multiple files could be converted to the same destination file. In such a case
+// the extension is appended to the name of all these files ("folder/report_docx").
+// If the extension is also the same (same file name in different folders), the
+// file ID is appended instead ("report_123"). Files of generic WebDAV servers have no
+// file ID → a hash of the path is used ("report_1a2b3c4d")
+func (job *convertJob) getSourceMap(files []nextcloud.NcFile) map[string]nextcloud.NcFile {
+	byName := make(map[string][]nextcloud.NcFile)
+	for _, file := range files {
+		name := file.Path[len(job.job.SourceDir) : len(file.Path)-len(file.Extension)]
+		if !job.job.KeepFolders {
+			name = name[strings.LastIndex(name, "/")+1:]
+		}
+		byName[name] = append(byName[name], file)
+	}
+
+	rtc := make(map[string]nextcloud.NcFile)
+	for name, sameName := range byName {
+		if len(sameName) == 1 {
+			rtc[name] = sameName[0]
+			continue
+		}
+
+		uniqueExtensions := make(map[string]bool)
+		for _, file := range sameName {
+			uniqueExtensions[strings.ToLower(file.Extension)] = true
+		}
 
-	return job.job.DestinationDir + name + ".pdf"
+		for _, file := range sameName {
+			logger.Debug("Duplicate document name: %s", file.Path)
+			if len(uniqueExtensions) == len(sameName) {
+				rtc[name+"_"+strings.TrimPrefix(strings.ToLower(file.Extension), ".")] = file
+			} else if file.Fileid != 0 {
+				rtc[name+"_"+file.ID()] = file
+			} else {
+				hash := sha256.Sum256([]byte(file.Path))
+				rtc[name+"_"+hex.EncodeToString(hash[:4])] = file
+			}
+		}
+	}
+
+	return rtc
 }
 
-// Converts the source file to the destination file utilizing the onlyoffice convert api
-func (job *convertJob) convertFile(sourceFile string, sourceid int, destinationFile string) {
-	logger.Debug("Converting %s (%d) to %s", sourceFile, sourceid, destinationFile)
+// Returns the content types for the given file extensions of the source documents.
+// If no extensions are given, all office documents that can be converted to the format are returned
+func getSourceContentTypes(extensions []string, format models.Format) ([]string, error) {
+	if len(extensions) == 0 {
+		for extension := range models.OfficeContentTypes {
+			if format.SupportsSource(extension) {
+				extensions = append(extensions, extension)
+			}
+		}
+		sort.Strings(extensions)
+	}
 
-	client := http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest(http.MethodGet, job.ncUser.NextcloudBaseUrl+"/apps/onlyoffice/downloadas", nil)
-	if err != nil {
-		logger.Error("%s", err)
+	var rtc []string
+	for _, extension := range extensions {
+		contentTypes, exists := models.OfficeContentTypes[strings.TrimPrefix(strings.ToLower(extension), ".")]
+		if !exists {
+			return nil, fmt.Errorf("unsupported source type given: '%s'", extension)
+		}
+		if !format.SupportsSource(extension) {
+			return nil, fmt.Errorf("'%s' documents can't be converted to '%s'", extension, format)
+		}
+		rtc = append(rtc, contentTypes...)
 	}
-	req.SetBasicAuth(job.ncUser.Username, job.ncUser.Password)
 
-	q := req.URL.Query()
-	q.Add("fileId", fmt.Sprint(sourceid))
-	q.Add("toExtension", "pdf")
-	req.URL.RawQuery = q.Encode()
+	return rtc, nil
+}
+
+// Converts the source file to the destination file utilizing the configured converter
+func (job *convertJob) convertFile(ctx context.Context, source *nextcloud.NcFile, destinationFile string) error {
+	logger.Debug("Converting %s (%s) to %s", source.Path, source.ID(), destinationFile)
 
-	res, err := client.Do(req)
+	content, size, err := job.converter.Convert(ctx, source, job.format)
 	if err != nil {
-		logger.Error("Failed to access the convert api: %s", err)
-		return
+		return fmt.Errorf("failed to convert file %q: %s", source.Path, err)
 	}
+	defer content.Close()
 
-	if res.StatusCode != 200 {
-		body, _ := io.ReadAll(res.Body)
-		logger.Error("Failed to access the convert api (#%d). Do you have OnlyOffice installed?: %s", res.StatusCode, body)
-		return
+	reader := metrics.NewCountingReader(content)
+	if err := job.storage.Put(ctx, destinationFile, reader, size, source.ETag); err != nil {
+		return fmt.Errorf("failed to save file %q: %s", destinationFile, err)
 	}
+	metrics.UploadedBytes.WithLabelValues(job.getMetricLabels(false)...).Add(float64(reader.Count()))
+
+	return nil
+}
 
-	if err := nextcloud.UploadFile(job.ncUser, destinationFile, res.Body); err != nil {
-		logger.Error("Failed to upload file %q to nextcloud: %s", destinationFile, err)
+// Returns the labels of the job for the metrics
+func (job *convertJob) getMetricLabels(withFormat bool) []string {
+	rtc := []string{"office", getJobUser(job.ncUser), job.job.JobName}
+	if withFormat {
+		rtc = append(rtc, string(job.format))
 	}
 
-	res.Body.Close()
+	return rtc
 }
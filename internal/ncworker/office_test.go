@@ -0,0 +1,272 @@
+package ncworker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+	"git.rpjosh.de/ncDocConverter/internal/storage"
+)
+
+// Returns an office job that converts the documents of "docs/" to PDF files inside "pdf/"
+func newTestConvertJob(t *testing.T, job models.NcConvertJob) *convertJob {
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open the state: %s", err)
+	}
+
+	job.JobName = "office"
+	job.SourceDir = "docs/"
+	job.DestinationDir = "pdf/"
+	return &convertJob{
+		job:    &job,
+		ncUser: &models.NextcloudUser{NextcloudBaseUrl: "https://cloud.local", Username: "user"},
+		env:    NewEnvironment(&models.WebConfig{}, store),
+		format: models.PDF,
+		key:    "office/user/office",
+	}
+}
+
+// Returns a source document with the given file ID
+func newTestSource(path string, fileID int) nextcloud.NcFile {
+	return nextcloud.NcFile{Path: path, Extension: filepath.Ext(path), Fileid: fileID, ETag: "etag-" + path, Size: 10}
+}
+
+// Returns the names of the source map with the path of their source ("name=path")
+func getSourceNames(sourceMap map[string]nextcloud.NcFile) []string {
+	rtc := make([]string, 0, len(sourceMap))
+	for name, source := range sourceMap {
+		rtc = append(rtc, name+"="+source.Path)
+	}
+	sort.Strings(rtc)
+
+	return rtc
+}
+
+func TestGetSourceMap(t *testing.T) {
+	tests := []struct {
+		name        string
+		keepFolders bool
+		files       []nextcloud.NcFile
+		want        []string
+	}{
+		{
+			name:  "unique names",
+			files: []nextcloud.NcFile{newTestSource("docs/report.docx", 1), newTestSource("docs/a/notes.odt", 2)},
+			want:  []string{"notes=docs/a/notes.odt", "report=docs/report.docx"},
+		},
+		{
+			name:        "same name in different folders that are kept",
+			keepFolders: true,
+			files:       []nextcloud.NcFile{newTestSource("docs/a/report.docx", 1), newTestSource("docs/b/report.docx", 2)},
+			want:        []string{"a/report=docs/a/report.docx", "b/report=docs/b/report.docx"},
+		},
+		{
+			name:  "same name with different extensions",
+			files: []nextcloud.NcFile{newTestSource("docs/report.docx", 1), newTestSource("docs/report.odt", 2)},
+			want:  []string{"report_docx=docs/report.docx", "report_odt=docs/report.odt"},
+		},
+		{
+			name:  "same name and extension in different folders",
+			files: []nextcloud.NcFile{newTestSource("docs/report.docx", 1), newTestSource("docs/a/report.DOCX", 2)},
+			want:  []string{"report_1=docs/report.docx", "report_2=docs/a/report.DOCX"},
+		},
+		{
+			name: "only some extensions are different",
+			files: []nextcloud.NcFile{
+				newTestSource("docs/report.docx", 1), newTestSource("docs/a/report.docx", 2), newTestSource("docs/report.odt", 3),
+			},
+			want: []string{"report_1=docs/report.docx", "report_2=docs/a/report.docx", "report_3=docs/report.odt"},
+		},
+		{
+			name:  "same name without file IDs",
+			files: []nextcloud.NcFile{newTestSource("docs/report.docx", 0), newTestSource("docs/a/report.docx", 0)},
+			want:  []string{"report_c160eee7=docs/report.docx", "report_c4573b99=docs/a/report.docx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newTestConvertJob(t, models.NcConvertJob{KeepFolders: models.StringBool(tt.keepFolders)})
+
+			got := getSourceNames(job.getSourceMap(tt.files))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceMapRename(t *testing.T) {
+	job := newTestConvertJob(t, models.NcConvertJob{})
+	report := newTestSource("docs/report.docx", 1)
+	job.env.State.Set(job.key, job.getStateEntry(&report, "pdf/report.pdf", report.LastModified))
+
+	// A second document with the same name is added → the converted file of the first one is renamed
+	sourceMap := job.getSourceMap([]nextcloud.NcFile{report, newTestSource("docs/report.odt", 2)})
+	destinationMap := map[string]storage.File{"report": {Path: "pdf/report.pdf"}}
+	moves := job.getFilesToMove(sourceMap, destinationMap)
+
+	if len(moves) != 1 || moves[0].from != "pdf/report.pdf" || moves[0].to != "pdf/report_docx.pdf" {
+		t.Fatalf("expected the move from pdf/report.pdf to pdf/report_docx.pdf, got %+v", moves)
+	}
+	if len(destinationMap) != 0 {
+		t.Errorf("the moved file was not removed from the destination map: %v", destinationMap)
+	}
+}
+
+// Returns a nextcloud client whose search always returns the given source documents
+func newTestSearchClient(t *testing.T, sources []nextcloud.NcFile) *nextcloud.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "SEARCH" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">`)
+		for _, source := range sources {
+			fmt.Fprintf(w, `<d:response><d:href>/remote.php/dav/files/user/%s</d:href><d:propstat><d:prop>
+				<d:getlastmodified>Thu, 01 Jan 2026 00:00:00 GMT</d:getlastmodified><oc:size>%d</oc:size>
+				<oc:fileid>%d</oc:fileid><d:getetag>"%s"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`,
+				source.Path, source.Size, source.Fileid, source.ETag)
+		}
+		fmt.Fprint(w, `</d:multistatus>`)
+	}))
+	t.Cleanup(server.Close)
+
+	return nextcloud.NewClient(&models.NextcloudUser{NextcloudBaseUrl: server.URL, Username: "user"})
+}
+
+func TestPlanMoves(t *testing.T) {
+	renamed := newTestSource("docs/new.docx", 1)
+	modified := renamed
+	modified.ETag = "etag-modified"
+	moved := renamed
+	moved.Path = "docs/a/new.docx"
+
+	tests := []struct {
+		name    string
+		sources []nextcloud.NcFile
+		// Converted files inside the destination directory
+		files []string
+		// Destination of the source with the ID 1 inside the state
+		stateDestination string
+		// Expected moves ("from>to"), conversions ("destination:reason") and deletions
+		wantMoves    []string
+		wantConverts []string
+		wantDeletes  []string
+	}{
+		{
+			name:             "renamed source",
+			sources:          []nextcloud.NcFile{renamed},
+			files:            []string{"old.pdf"},
+			stateDestination: "pdf/old.pdf",
+			wantMoves:        []string{"pdf/old.pdf>pdf/new.pdf"},
+		},
+		{
+			name:             "renamed and modified source",
+			sources:          []nextcloud.NcFile{modified},
+			files:            []string{"old.pdf"},
+			stateDestination: "pdf/old.pdf",
+			wantMoves:        []string{"pdf/old.pdf>pdf/new.pdf"},
+			wantConverts:     []string{"pdf/new.pdf:modified"},
+		},
+		{
+			name:             "moved into a folder",
+			sources:          []nextcloud.NcFile{moved},
+			files:            []string{"old.pdf"},
+			stateDestination: "pdf/old.pdf",
+			wantMoves:        []string{"pdf/old.pdf>pdf/new.pdf"},
+		},
+		{
+			name:             "new destination is occupied",
+			sources:          []nextcloud.NcFile{renamed},
+			files:            []string{"old.pdf", "new.pdf"},
+			stateDestination: "pdf/old.pdf",
+			wantConverts:     []string{"pdf/new.pdf:modified"},
+			wantDeletes:      []string{"pdf/old.pdf"},
+		},
+		{
+			name:             "old file is used by another source",
+			sources:          []nextcloud.NcFile{renamed, newTestSource("docs/old.docx", 2)},
+			files:            []string{"old.pdf"},
+			stateDestination: "pdf/old.pdf",
+			wantConverts:     []string{"pdf/new.pdf:new"},
+		},
+		{
+			name:             "old file does not exist",
+			sources:          []nextcloud.NcFile{renamed},
+			stateDestination: "pdf/old.pdf",
+			wantConverts:     []string{"pdf/new.pdf:new"},
+		},
+		{
+			name:         "unknown source",
+			sources:      []nextcloud.NcFile{renamed},
+			files:        []string{"old.pdf"},
+			wantConverts: []string{"pdf/new.pdf:new"},
+			wantDeletes:  []string{"pdf/old.pdf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := newTestConvertJob(t, models.NcConvertJob{})
+			job.client = newTestSearchClient(t, tt.sources)
+
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "pdf"), 0755); err != nil {
+				t.Fatalf("failed to create the destination directory: %s", err)
+			}
+			for _, file := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, "pdf", file), []byte(file), 0644); err != nil {
+					t.Fatalf("failed to create the file: %s", err)
+				}
+			}
+			var err error
+			if job.storage, err = storage.NewLocal(dir); err != nil {
+				t.Fatalf("failed to create the storage: %s", err)
+			}
+			if tt.stateDestination != "" {
+				job.env.State.Set(job.key, job.getStateEntry(&renamed, tt.stateDestination, time.Now()))
+			}
+
+			plan, err := job.plan(context.Background())
+			if err != nil {
+				t.Fatalf("failed to plan the job: %s", err)
+			}
+
+			moves := []string{}
+			for _, move := range plan.filesToMove {
+				moves = append(moves, move.from+">"+move.to)
+			}
+			converts := []string{}
+			for _, cvt := range plan.filesToConvert {
+				converts = append(converts, cvt.destination+":"+cvt.reason)
+			}
+			deletes := []string{}
+			for _, file := range plan.filesToDelete {
+				deletes = append(deletes, file.Path)
+			}
+
+			if strings.Join(moves, ",") != strings.Join(tt.wantMoves, ",") {
+				t.Errorf("moves: got %v, want %v", moves, tt.wantMoves)
+			}
+			if strings.Join(converts, ",") != strings.Join(tt.wantConverts, ",") {
+				t.Errorf("conversions: got %v, want %v", converts, tt.wantConverts)
+			}
+			if strings.Join(deletes, ",") != strings.Join(tt.wantDeletes, ",") {
+				t.Errorf("deletions: got %v, want %v", deletes, tt.wantDeletes)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package ncworker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+)
+
+// All actions a job would perform in its current execution
+type Plan struct {
+	JobName   string    `json:"jobName"`
+	JobType   string    `json:"jobType"`
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	Directories []string         `json:"directories"`
+	Moves       []PlanMove       `json:"moves"`
+	Conversions []PlanConversion `json:"conversions"`
+	Deletions   []string         `json:"deletions"`
+	// Files without a source that would be moved into the archive directory
+	Archives []PlanMove `json:"archives"`
+}
+
+// A converted file that would be moved to a new location
+type PlanMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// A document that would be converted
+type PlanConversion struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Why the document has to be converted
+	Reason string `json:"reason"`
+}
+
+// Reasons for a conversion
+const (
+	reasonNew      = "new"
+	reasonModified = "modified"
+)
+
+func newPlan(jobType string, jobName string, user string) *Plan {
+	return &Plan{
+		JobName:     jobName,
+		JobType:     jobType,
+		User:        user,
+		CreatedAt:   time.Now(),
+		Directories: []string{},
+		Moves:       []PlanMove{},
+		Conversions: []PlanConversion{},
+		Deletions:   []string{},
+		Archives:    []PlanMove{},
+	}
+}
+
+// Sorts all actions by their path
+func (p *Plan) sort() {
+	sort.Strings(p.Directories)
+	sort.Slice(p.Moves, func(i, j int) bool { return p.Moves[i].From < p.Moves[j].From })
+	sort.Slice(p.Conversions, func(i, j int) bool { return p.Conversions[i].Source < p.Conversions[j].Source })
+	sort.Strings(p.Deletions)
+	sort.Slice(p.Archives, func(i, j int) bool { return p.Archives[i].From < p.Archives[j].From })
+}
+
+// Returns a human-readable representation of the plan
+func (p *Plan) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Plan of %s job \"%s\": %d conversions, %d moves, %d deletions, %d archived, %d directories\n",
+		p.JobType, p.JobName, len(p.Conversions), len(p.Moves), len(p.Deletions), len(p.Archives), len(p.Directories))
+	for _, dir := range p.Directories {
+		fmt.Fprintf(&b, "  mkdir   %s\n", dir)
+	}
+	for _, move := range p.Moves {
+		fmt.Fprintf(&b, "  move    %s → %s\n", move.From, move.To)
+	}
+	for _, conv := range p.Conversions {
+		fmt.Fprintf(&b, "  convert %s → %s (%s)\n", conv.Source, conv.Destination, conv.Reason)
+	}
+	for _, del := range p.Deletions {
+		fmt.Fprintf(&b, "  delete  %s\n", del)
+	}
+	for _, archive := range p.Archives {
+		fmt.Fprintf(&b, "  archive %s → %s\n", archive.From, archive.To)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+var invalidFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Prints the plan and writes it as a JSON file into the "plans" folder of the data directory
+func (p *Plan) report(dataDir string) {
+	logger.Info("[Dry run] %s", p.String())
+
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		logger.Error("Failed to encode the plan: %s", err)
+		return
+	}
+
+	dir := filepath.Join(dataDir, "plans")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Error("Failed to create the directory for plans: %s", err)
+		return
+	}
+
+	name := p.JobType + "_" + p.User + "_" + p.JobName
+	file := filepath.Join(dir, invalidFileNameChars.ReplaceAllString(name, "_")+".json")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		logger.Error("Failed to write the plan: %s", err)
+		return
+	}
+	logger.Info("[Dry run] Plan written to %s", file)
+}
@@ -0,0 +1,113 @@
+package ncworker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/credentials"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Reads the job file, applies the saved app passwords and validates the jobs
+func LoadUsers(config *models.WebConfig) (*models.NcConvertUsers, error) {
+	users, err := models.ParseConvertUsers(config.Server.JobFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if store, err := credentials.Open(config.Server.DataDir); err != nil {
+		logger.Error("Unable to load the saved app passwords: %s", err)
+	} else {
+		store.Apply(users)
+	}
+
+	if err := users.Validate(!config.Server.OneShot); err != nil {
+		return nil, fmt.Errorf("the job file '%s' is invalid:\n%w", config.Server.JobFile, err)
+	}
+
+	return users, nil
+}
+
+// Reloads the jobs when the job file changed or the signal SIGHUP was received
+func (s *NcConvertScheduler) watchJobFile() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// The directory is watched because editors and Kubernetes (ConfigMap) replace the file instead of writing to it
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(s.config.Server.JobFile))
+	}
+	if err != nil {
+		logger.Warning("Unable to watch the job file for changes (reload it with SIGHUP): %s", err)
+	} else {
+		defer watcher.Close()
+		events = watcher.Events
+		watchErrors = watcher.Errors
+	}
+
+	// Multiple events are emitted for a single change
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-events:
+			debounce = time.After(time.Second)
+		case err := <-watchErrors:
+			logger.Warning("Error while watching the job file: %s", err)
+		case <-debounce:
+			debounce = nil
+			s.reloadJobFile(false)
+		case <-hup:
+			logger.Info("Received SIGHUP")
+			s.reloadJobFile(true)
+		}
+	}
+}
+
+// Loads the job file again and applies the changed jobs. If the file is invalid, the current jobs are kept.
+// Without force, the jobs are only reloaded if the content of the file changed
+func (s *NcConvertScheduler) reloadJobFile(force bool) {
+	hash, err := getFileHash(s.config.Server.JobFile)
+	if err != nil {
+		logger.Error("Failed to read the job file: %s", err)
+		return
+	}
+	if !force && hash == s.jobFileHash {
+		return
+	}
+
+	users, err := LoadUsers(s.config)
+	if err != nil {
+		logger.Error("Keeping the current jobs: %s", err)
+		return
+	}
+	if err := s.Reload(users); err != nil {
+		logger.Error("Keeping the current jobs: %s", err)
+		return
+	}
+	// Only a successfully applied file is skipped on the next change event
+	s.jobFileHash = hash
+
+	logger.Info("Reloaded the job file %s", s.config.Server.JobFile)
+}
+
+// Returns the sha256 hash of the content of the file
+func getFileHash(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
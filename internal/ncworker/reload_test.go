@@ -0,0 +1,192 @@
+package ncworker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+)
+
+// Writes a job file with a BookStack job for every name. The jobs are executed
+// with the given cron expression
+func writeJobFile(t *testing.T, path string, jobs map[string]string) {
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	definitions := make([]string, len(names))
+	for i, name := range names {
+		definitions[i] = fmt.Sprintf(`{"jobName": %q, "destinationDir": %q, "format": "html", "execution": %q,
+			"destination": {"type": "local", "path": %q}}`, name, name+"/", jobs[name], filepath.Dir(path))
+	}
+
+	content := fmt.Sprintf(`{"nextcloudUsers": [{"nextcloudUrl": "https://cloud.local", "username": "user", "password": "secret",
+		"bookStack": {"url": "https://wiki.local", "apiToken": "id:secret", "jobs": [%s]}}]}`, strings.Join(definitions, ","))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write the job file: %s", err)
+	}
+}
+
+// Returns the scheduled jobs indexed by their name
+func getScheduledJobs(s *NcConvertScheduler) map[string]*scheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rtc := make(map[string]*scheduledJob)
+	for _, job := range s.jobs {
+		rtc[job.jobName] = job
+	}
+	return rtc
+}
+
+func TestReloadJobFile(t *testing.T) {
+	dir := t.TempDir()
+	config := &models.WebConfig{Server: models.Server{JobFile: filepath.Join(dir, "jobs.json"), DataDir: dir}}
+	writeJobFile(t, config.Server.JobFile, map[string]string{"kept": "0 1 * * *", "changed": "0 2 * * *", "removed": "0 3 * * *"})
+
+	users, err := LoadUsers(config)
+	if err != nil {
+		t.Fatalf("failed to load the job file: %s", err)
+	}
+	store, err := state.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open the state: %s", err)
+	}
+	s := newScheduler(users, config, store)
+	defer s.scheduler.Stop()
+	if err := s.Reload(users); err != nil {
+		t.Fatalf("failed to schedule the jobs: %s", err)
+	}
+	s.jobFileHash, _ = getFileHash(config.Server.JobFile)
+
+	tests := []struct {
+		name string
+		// Jobs of the new job file with their cron expression
+		jobs map[string]string
+		// Content of an invalid job file
+		invalid string
+		// Expected jobs with their cron expression after the reload
+		want map[string]string
+		// Jobs that have to be kept without being scheduled again
+		wantKept []string
+		// If the job file is applied
+		wantApplied bool
+	}{
+		{
+			name:        "unchanged, changed, removed and added jobs",
+			jobs:        map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *", "added": "0 5 * * *"},
+			want:        map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *", "added": "0 5 * * *"},
+			wantKept:    []string{"kept"},
+			wantApplied: true,
+		},
+		{
+			name:     "invalid cron expression",
+			jobs:     map[string]string{"kept": "0 1 * * *", "changed": "not a cron", "added": "0 5 * * *"},
+			want:     map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *", "added": "0 5 * * *"},
+			wantKept: []string{"kept", "changed", "added"},
+		},
+		{
+			name:     "syntax error",
+			invalid:  `{"nextcloudUsers": [`,
+			want:     map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *", "added": "0 5 * * *"},
+			wantKept: []string{"kept", "changed", "added"},
+		},
+		{
+			name:        "valid file after an invalid one",
+			jobs:        map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *"},
+			want:        map[string]string{"kept": "0 1 * * *", "changed": "0 4 * * *"},
+			wantKept:    []string{"kept", "changed"},
+			wantApplied: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := getScheduledJobs(s)
+			hashBefore := s.jobFileHash
+
+			if tt.invalid != "" {
+				if err := os.WriteFile(config.Server.JobFile, []byte(tt.invalid), 0644); err != nil {
+					t.Fatalf("failed to write the job file: %s", err)
+				}
+			} else {
+				writeJobFile(t, config.Server.JobFile, tt.jobs)
+			}
+			s.reloadJobFile(false)
+
+			after := getScheduledJobs(s)
+			if len(after) != len(tt.want) || s.scheduler.Len() != len(tt.want) {
+				t.Errorf("expected %d jobs, got %d jobs and %d cron jobs", len(tt.want), len(after), s.scheduler.Len())
+			}
+			for name, execution := range tt.want {
+				job, exists := after[name]
+				if !exists {
+					t.Errorf("the job '%s' is not scheduled", name)
+				} else if job.execution != execution {
+					t.Errorf("execution of '%s': got '%s', want '%s'", name, job.execution, execution)
+				}
+			}
+			for _, name := range tt.wantKept {
+				if after[name] != before[name] || after[name].cronJob != before[name].cronJob {
+					t.Errorf("the job '%s' was scheduled again", name)
+				}
+			}
+
+			// An invalid file is loaded again on the next change
+			hash, _ := getFileHash(config.Server.JobFile)
+			if tt.wantApplied && s.jobFileHash != hash {
+				t.Error("the hash of the applied job file was not saved")
+			} else if !tt.wantApplied && s.jobFileHash != hashBefore {
+				t.Error("the hash of the invalid job file was saved")
+			}
+		})
+	}
+}
+
+func TestReloadRollback(t *testing.T) {
+	dir := t.TempDir()
+	config := &models.WebConfig{Server: models.Server{JobFile: filepath.Join(dir, "jobs.json"), DataDir: dir}}
+	writeJobFile(t, config.Server.JobFile, map[string]string{"first": "0 1 * * *", "second": "0 2 * * *"})
+
+	users, err := LoadUsers(config)
+	if err != nil {
+		t.Fatalf("failed to load the job file: %s", err)
+	}
+	store, err := state.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open the state: %s", err)
+	}
+	s := newScheduler(users, config, store)
+	defer s.scheduler.Stop()
+	if err := s.Reload(users); err != nil {
+		t.Fatalf("failed to schedule the jobs: %s", err)
+	}
+	before := getScheduledJobs(s)
+
+	// The validation is skipped, so that gocron rejects the cron expression
+	writeJobFile(t, config.Server.JobFile, map[string]string{"first": "0 5 * * *", "second": "invalid", "third": "0 6 * * *"})
+	changed, err := models.ParseConvertUsers(config.Server.JobFile)
+	if err != nil {
+		t.Fatalf("failed to parse the job file: %s", err)
+	}
+	if err := s.Reload(changed); err == nil {
+		t.Fatal("expected an error for the invalid cron expression")
+	}
+
+	after := getScheduledJobs(s)
+	if len(after) != 2 || s.scheduler.Len() != 2 {
+		t.Fatalf("expected the 2 old jobs, got %d jobs and %d cron jobs", len(after), s.scheduler.Len())
+	}
+	for name, job := range before {
+		if after[name] != job {
+			t.Errorf("the job '%s' was not kept", name)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package ncworker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
+	"git.rpjosh.de/ncDocConverter/pkg/utils"
+)
+
+var (
+	// Returned when no job exists with the given ID
+	ErrJobNotFound = errors.New("job does not exist")
+	// Returned when the job should be started while it's still running
+	ErrJobRunning = errors.New("job is already running")
+	// Returned when a job should be canceled that is not running
+	ErrJobNotRunning = errors.New("job is not running")
+	// Returned when no run exists with the given ID
+	ErrRunNotFound = errors.New("run does not exist")
+	// Returned when a job should be started while the program is shutting down
+	ErrShuttingDown = errors.New("the program is shutting down")
+)
+
+// Maximum count of runs that are kept in memory
+const maxRuns = 100
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunCanceled  RunStatus = "canceled"
+)
+
+// Summary of the changes of a run
+type RunResult struct {
+	Converted int32 `json:"converted"`
+	Moved     int32 `json:"moved"`
+	Deleted   int32 `json:"deleted"`
+	// Count of files that could not be converted, moved or deleted
+	Failed int32 `json:"failed"`
+
+	// The planned changes if the job was executed as a dry run
+	Plan *Plan `json:"plan,omitempty"`
+}
+
+// A single execution of a job
+type Run struct {
+	ID      int64  `json:"id"`
+	JobID   string `json:"jobId"`
+	JobName string `json:"jobName"`
+	// What started the run ("schedule", "api" or "webhook")
+	Trigger string    `json:"trigger"`
+	Status  RunStatus `json:"status"`
+
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	Result *RunResult `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	// Labels of the job for the metrics
+	labels []string
+}
+
+// A scheduled job
+type JobInfo struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	User      string     `json:"user"`
+	Execution string     `json:"execution"`
+	NextRun   *time.Time `json:"nextRun,omitempty"`
+	Running   bool       `json:"running"`
+	LastRun   *Run       `json:"lastRun,omitempty"`
+}
+
+// Returns a short ID of the job that can be used in URLs
+func getJobID(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])[0:12]
+}
+
+// Registers a new run of the job. The returned context is canceled when the run is canceled
+func (s *NcConvertScheduler) startRun(key string, trigger string) (*Run, context.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.env.IsStopping() {
+		return nil, nil, ErrShuttingDown
+	}
+	if _, running := s.active[key]; running {
+		return nil, nil, ErrJobRunning
+	}
+
+	name := key
+	labels := []string{"", "", key}
+	if job, exists := s.jobs[key]; exists {
+		name = job.jobName
+		labels = []string{job.jobType, job.user, job.jobName}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.nextRunID++
+	run := &Run{
+		ID:        s.nextRunID,
+		JobID:     getJobID(key),
+		JobName:   name,
+		Trigger:   trigger,
+		Status:    RunRunning,
+		StartedAt: time.Now(),
+		cancel:    cancel,
+		labels:    labels,
+	}
+
+	s.active[key] = run
+	s.runs = append(s.runs, run)
+	if len(s.runs) > maxRuns {
+		s.runs = s.runs[len(s.runs)-maxRuns:]
+	}
+	s.running.Add(1)
+	metrics.JobsRunning.Inc()
+
+	return run, ctx, nil
+}
+
+// Executes the job and saves the result in the run
+func (s *NcConvertScheduler) execute(key string, run *Run, job Job, ctx context.Context) {
+	defer s.running.Done()
+	result, err := job.ExecuteJob(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	run.FinishedAt = &now
+	run.Result = result
+	switch {
+	case ctx.Err() != nil:
+		run.Status = RunCanceled
+	case err != nil:
+		logger.Error("%s", utils.FirstCharToUppercase(err.Error()))
+		run.Status = RunFailed
+		run.Error = err.Error()
+	case result != nil && result.Failed > 0:
+		run.Status = RunFailed
+		run.Error = fmt.Sprintf("%d files could not be processed", result.Failed)
+	default:
+		run.Status = RunSucceeded
+	}
+
+	run.cancel()
+	delete(s.active, key)
+
+	metrics.JobsRunning.Dec()
+	metrics.JobRunDuration.WithLabelValues(append(run.labels, string(run.Status))...).Observe(now.Sub(run.StartedAt).Seconds())
+	if run.Status == RunSucceeded {
+		metrics.JobLastSuccess.WithLabelValues(run.labels...).Set(float64(now.Unix()))
+	}
+}
+
+// Returns the key and the scheduled job with the given ID
+func (s *NcConvertScheduler) findJob(id string) (string, *scheduledJob) {
+	for key, job := range s.jobs {
+		if job.id == id {
+			return key, job
+		}
+	}
+
+	return "", nil
+}
+
+// Starts the job with the given ID in the background
+func (s *NcConvertScheduler) Trigger(id string) (Run, error) {
+	s.mu.Lock()
+	key, job := s.findJob(id)
+	s.mu.Unlock()
+	if job == nil {
+		return Run{}, ErrJobNotFound
+	}
+
+	run, ctx, err := s.startRun(key, "api")
+	if err != nil {
+		return Run{}, err
+	}
+	go s.execute(key, run, job.job, ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *run, nil
+}
+
+// Starts all jobs that are not running in the background
+func (s *NcConvertScheduler) TriggerAll() ([]Run, error) {
+	rtc := []Run{}
+	for _, job := range s.Jobs() {
+		run, err := s.Trigger(job.ID)
+		if errors.Is(err, ErrJobRunning) || errors.Is(err, ErrJobNotFound) {
+			continue
+		} else if err != nil {
+			return rtc, err
+		}
+		rtc = append(rtc, run)
+	}
+
+	return rtc, nil
+}
+
+// Cancels the running job with the given ID
+func (s *NcConvertScheduler) Cancel(id string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, job := s.findJob(id)
+	if job == nil {
+		return Run{}, ErrJobNotFound
+	}
+	run, running := s.active[key]
+	if !running {
+		return Run{}, ErrJobNotRunning
+	}
+
+	logger.Info("Canceling %s", job.name)
+	run.cancel()
+	return *run, nil
+}
+
+// Returns all scheduled jobs
+func (s *NcConvertScheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rtc := make([]JobInfo, 0, len(s.jobs))
+	for key := range s.jobs {
+		rtc = append(rtc, s.getJobInfo(key))
+	}
+	sort.Slice(rtc, func(i, j int) bool {
+		if rtc[i].User != rtc[j].User {
+			return rtc[i].User < rtc[j].User
+		}
+		if rtc[i].Type != rtc[j].Type {
+			return rtc[i].Type < rtc[j].Type
+		}
+		return rtc[i].Name < rtc[j].Name
+	})
+
+	return rtc
+}
+
+// Returns the scheduled job with the given ID
+func (s *NcConvertScheduler) Job(id string) (JobInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, job := s.findJob(id)
+	if job == nil {
+		return JobInfo{}, ErrJobNotFound
+	}
+
+	return s.getJobInfo(key), nil
+}
+
+func (s *NcConvertScheduler) getJobInfo(key string) JobInfo {
+	job := s.jobs[key]
+	rtc := JobInfo{
+		ID:        job.id,
+		Type:      job.jobType,
+		Name:      job.jobName,
+		User:      job.user,
+		Execution: job.execution,
+	}
+
+	if nextRun := job.cronJob.NextRun(); !nextRun.IsZero() {
+		rtc.NextRun = &nextRun
+	}
+	_, rtc.Running = s.active[key]
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if s.runs[i].JobID == job.id {
+			run := *s.runs[i]
+			rtc.LastRun = &run
+			break
+		}
+	}
+
+	return rtc
+}
+
+// Returns the latest runs (newest first). If a job ID is given, only the runs of this job are returned
+func (s *NcConvertScheduler) Runs(jobID string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rtc := []Run{}
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if jobID == "" || s.runs[i].JobID == jobID {
+			rtc = append(rtc, *s.runs[i])
+		}
+	}
+
+	return rtc
+}
+
+// Returns the run with the given ID
+func (s *NcConvertScheduler) Run(id int64) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, run := range s.runs {
+		if run.ID == id {
+			return *run, nil
+		}
+	}
+
+	return Run{}, ErrRunNotFound
+}
@@ -0,0 +1,230 @@
+package ncworker
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+)
+
+// Returned when the secret of a webhook does not match any BookStack instance
+var ErrInvalidSecret = errors.New("invalid webhook secret")
+
+// The payload of a BookStack webhook
+type BookStackEvent struct {
+	Event       string `json:"event"`
+	Text        string `json:"text"`
+	RelatedItem struct {
+		ID     int    `json:"id"`
+		BookID int    `json:"book_id"`
+		Name   string `json:"name"`
+	} `json:"related_item"`
+}
+
+// Changes of a job that were received by webhooks and are not executed yet
+type pendingUpdate struct {
+	// Events that were not checked yet if they affect the job.
+	// Checking them may require requests to BookStack, so it's done after the debounce time
+	events map[BookStackEvent]bool
+	// IDs of the changed books
+	books map[int]bool
+	// If the whole job has to be executed
+	full  bool
+	timer *time.Timer
+}
+
+// Converts only the changed books of a BookStack job
+type bookUpdate struct {
+	job   *BsJob
+	books []int
+}
+
+func (u *bookUpdate) ExecuteJob(ctx context.Context) (*RunResult, error) {
+	return u.job.ExecuteBooks(ctx, u.books)
+}
+
+// Queues the event for all BookStack jobs of the instance with the secret. After the debounce time
+// the jobs that are affected by the events are executed. The names of the jobs are returned
+func (s *NcConvertScheduler) HandleBookStackEvent(secret string, event BookStackEvent) ([]string, error) {
+	// The secret identifies the BookStack instance
+	s.mu.Lock()
+	jobs := make(map[string]*BsJob)
+	for key, job := range s.jobs {
+		bsJob, isBookStack := job.job.(*BsJob)
+		if !isBookStack {
+			continue
+		}
+
+		expected := string(bsJob.ncUser.BookStack.WebhookSecret)
+		if expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(secret)) == 1 {
+			jobs[key] = bsJob
+		}
+	}
+	s.mu.Unlock()
+
+	if len(jobs) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	rtc := []string{}
+	for key, job := range jobs {
+		s.queueEvent(key, event)
+		rtc = append(rtc, job.job.JobName)
+	}
+	sort.Strings(rtc)
+	logger.Info("Received BookStack event %s (%s) for the jobs %v", event.Event, event.RelatedItem.Name, rtc)
+
+	return rtc, nil
+}
+
+// Returns if the job is affected by the event. If only some books changed, their IDs are returned.
+// Otherwise the whole job has to be executed
+func (job *BsJob) getAffectedBooks(event *BookStackEvent) (bool, []int) {
+	switch {
+	case event.Event == "page_move" || event.Event == "chapter_move":
+		// The old book is not contained in the event
+		return true, nil
+	case strings.HasPrefix(event.Event, "page_") || strings.HasPrefix(event.Event, "chapter_"):
+		return job.isAffectedByBook(event.RelatedItem.BookID, "")
+	case event.Event == "book_delete":
+		_, known := job.env.State.Get(job.key, strconv.Itoa(event.RelatedItem.ID))
+		return known, nil
+	case strings.HasPrefix(event.Event, "book_"):
+		return job.isAffectedByBook(event.RelatedItem.ID, event.RelatedItem.Name)
+	case strings.HasPrefix(event.Event, "bookshelf_"):
+		return job.matchesShelf(event.RelatedItem.Name) || job.job.IncludeBooksWithoutShelve, nil
+	default:
+		return false, nil
+	}
+}
+
+// Returns if the changed book belongs to the job. If the name is not given, it's requested
+// from BookStack for books that were not converted by the job yet
+func (job *BsJob) isAffectedByBook(id int, name string) (bool, []int) {
+	if _, known := job.env.State.Get(job.key, strconv.Itoa(id)); known {
+		return true, []int{id}
+	}
+
+	if name == "" {
+		b, err := job.getBook(id)
+		if err != nil {
+			logger.Warning("Failed to get book %d of BookStack job \"%s\": %s", id, job.job.JobName, err)
+			return false, nil
+		}
+		name = b.Name
+	}
+
+	// The shelves of a new book are only determined by a full execution
+	return job.matchesBook(name), nil
+}
+
+// Adds the event to the pending update of the job
+func (s *NcConvertScheduler) queueEvent(key string, event BookStackEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.getPendingUpdate(key).events[event] = true
+}
+
+// Adds the changed books to the pending update of the job. Without books the whole job is executed
+func (s *NcConvertScheduler) queueUpdate(key string, books []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := s.getPendingUpdate(key)
+	if books == nil {
+		update.full = true
+	}
+	for _, id := range books {
+		update.books[id] = true
+	}
+}
+
+// Returns the pending update of the job and restarts the debounce time. The update is executed
+// when no further events were received within the debounce time.
+// The lock (mu) has to be held
+func (s *NcConvertScheduler) getPendingUpdate(key string) *pendingUpdate {
+	debounce := time.Duration(s.config.Server.WebhookDebounce) * time.Second
+	update, exists := s.pending[key]
+	if !exists {
+		update = &pendingUpdate{events: make(map[BookStackEvent]bool), books: make(map[int]bool)}
+		update.timer = time.AfterFunc(debounce, func() { s.executeUpdate(key) })
+		s.pending[key] = update
+	} else {
+		update.timer.Reset(debounce)
+	}
+
+	return update
+}
+
+// Adds the books that are affected by the received events to the update
+func (update *pendingUpdate) resolveEvents(job *BsJob) {
+	for event := range update.events {
+		if update.full {
+			break
+		}
+
+		affected, books := job.getAffectedBooks(&event)
+		if !affected {
+			continue
+		}
+		if books == nil {
+			update.full = true
+		}
+		for _, id := range books {
+			update.books[id] = true
+		}
+	}
+	update.events = nil
+}
+
+// Executes the pending update of the job
+func (s *NcConvertScheduler) executeUpdate(key string) {
+	s.mu.Lock()
+	update, exists := s.pending[key]
+	delete(s.pending, key)
+	job, scheduled := s.jobs[key]
+	s.mu.Unlock()
+	if !exists || !scheduled {
+		return
+	}
+
+	bsJob, isBookStack := job.job.(*BsJob)
+	if !isBookStack {
+		return
+	}
+
+	update.resolveEvents(bsJob)
+	if !update.full && len(update.books) == 0 {
+		logger.Debug("The received events don't affect the BookStack job \"%s\"", bsJob.job.JobName)
+		return
+	}
+
+	// Without books the whole job is executed
+	var books []int
+	var exec Job = bsJob
+	if !update.full {
+		books = make([]int, 0, len(update.books))
+		for id := range update.books {
+			books = append(books, id)
+		}
+		sort.Ints(books)
+		exec = &bookUpdate{job: bsJob, books: books}
+	}
+
+	run, ctx, err := s.startRun(key, "webhook")
+	if errors.Is(err, ErrJobRunning) {
+		// Try again after the current run finished
+		s.queueUpdate(key, books)
+		return
+	} else if err != nil {
+		return
+	}
+
+	s.execute(key, run, exec, ctx)
+}
@@ -0,0 +1,170 @@
+package ncworker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/state"
+)
+
+// Returns a BookStack event for the item with the given ID
+func newTestEvent(event string, id int, bookID int, name string) BookStackEvent {
+	rtc := BookStackEvent{Event: event}
+	rtc.RelatedItem.ID = id
+	rtc.RelatedItem.BookID = bookID
+	rtc.RelatedItem.Name = name
+
+	return rtc
+}
+
+func TestGetAffectedBooks(t *testing.T) {
+	bs := newFakeBookStack(t,
+		[]fakeShelf{{id: 1, name: "Work", books: []int{1, 2}}, {id: 2, name: "Private", books: []int{3}}},
+		map[int]string{1: "Runbook", 2: "Notes", 3: "Diary", 4: "Howto"},
+	)
+
+	tests := []struct {
+		name  string
+		event BookStackEvent
+		// If books without a shelf are converted by the job
+		withoutShelve bool
+		want          bool
+		// IDs of the affected books (nil if the whole job is executed)
+		wantBooks []int
+	}{
+		{name: "page of a converted book", event: newTestEvent("page_update", 10, 1, "Page"), want: true, wantBooks: []int{1}},
+		{name: "chapter of a converted book", event: newTestEvent("chapter_create", 10, 1, "Chapter"), want: true, wantBooks: []int{1}},
+		{name: "page of a new matching book", event: newTestEvent("page_create", 10, 4, "Page"), want: true},
+		{name: "page of a book that does not match", event: newTestEvent("page_update", 10, 3, "Page")},
+		{name: "page of an unknown book", event: newTestEvent("page_update", 10, 99, "Page")},
+		{name: "moved page", event: newTestEvent("page_move", 10, 3, "Page"), want: true},
+		{name: "converted book", event: newTestEvent("book_update", 1, 0, "Runbook"), want: true, wantBooks: []int{1}},
+		{name: "new matching book", event: newTestEvent("book_create", 4, 0, "Howto"), want: true},
+		{name: "new book that does not match", event: newTestEvent("book_create", 5, 0, "Diary 2")},
+		{name: "deleted converted book", event: newTestEvent("book_delete", 1, 0, "Runbook"), want: true},
+		{name: "deleted book that was not converted", event: newTestEvent("book_delete", 3, 0, "Diary")},
+		{name: "matching shelf", event: newTestEvent("bookshelf_update", 1, 0, "Work"), want: true},
+		{name: "shelf that does not match", event: newTestEvent("bookshelf_update", 2, 0, "Private")},
+		{name: "shelf with books without shelves", event: newTestEvent("bookshelf_delete", 2, 0, "Private"), withoutShelve: true, want: true},
+		{name: "unrelated event", event: newTestEvent("user_create", 1, 0, "Runbook")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, _ := newTestBsJob(t, bs, models.BookStackJob{ShelvesRegex: "^Work$", BooksRegex: "o", IncludeBooksWithoutShelve: tt.withoutShelve})
+			job.env.State.Set(job.key, state.Entry{SourceID: "1", SourcePath: "Runbook", Destination: "wiki/Runbook.html"})
+
+			got, books := job.getAffectedBooks(&tt.event)
+			if got != tt.want || fmt.Sprint(books) != fmt.Sprint(tt.wantBooks) || (books == nil) != (tt.wantBooks == nil) {
+				t.Errorf("got %t %v, want %t %v", got, books, tt.want, tt.wantBooks)
+			}
+		})
+	}
+}
+
+// Returns the finished runs of the job (newest first). It's waited until the given count of runs finished
+func waitForRuns(t *testing.T, s *NcConvertScheduler, jobID string, count int) []Run {
+	timeout := time.Now().Add(5 * time.Second)
+	for {
+		runs := []Run{}
+		for _, run := range s.Runs(jobID) {
+			if run.FinishedAt != nil {
+				runs = append(runs, run)
+			}
+		}
+		if len(runs) >= count || time.Now().After(timeout) {
+			return runs
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWebhookDebounce(t *testing.T) {
+	bs := newFakeBookStack(t,
+		[]fakeShelf{{id: 1, name: "Work", books: []int{1, 2}}},
+		map[int]string{1: "Runbook", 2: "Notes"},
+	)
+
+	dir := t.TempDir()
+	config := &models.WebConfig{Server: models.Server{JobFile: filepath.Join(dir, "jobs.json"), DataDir: dir, WebhookDebounce: 1}}
+	content := fmt.Sprintf(`{"nextcloudUsers": [{"nextcloudUrl": "https://cloud.local", "username": "user", "password": "secret",
+		"bookStack": {"url": %q, "apiToken": "id:secret", "webhookSecret": "hook-secret", "jobs": [{"jobName": "wiki",
+			"destinationDir": "wiki/", "format": "html", "execution": "0 1 * * *", "destination": {"type": "local", "path": %q}}]}}]}`,
+		bs.server.URL, dir)
+	if err := os.WriteFile(config.Server.JobFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write the job file: %s", err)
+	}
+
+	users, err := LoadUsers(config)
+	if err != nil {
+		t.Fatalf("failed to load the job file: %s", err)
+	}
+	store, err := state.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open the state: %s", err)
+	}
+	s := newScheduler(users, config, store)
+	defer s.scheduler.Stop()
+	if err := s.Reload(users); err != nil {
+		t.Fatalf("failed to schedule the jobs: %s", err)
+	}
+
+	// The books are converted once, so that they are known
+	jobID := s.Jobs()[0].ID
+	if _, err := s.Trigger(jobID); err != nil {
+		t.Fatalf("failed to trigger the job: %s", err)
+	}
+	if runs := waitForRuns(t, s, jobID, 1); len(runs) != 1 || runs[0].Status != RunSucceeded {
+		t.Fatalf("the first run did not succeed: %+v", runs)
+	}
+	bs.mu.Lock()
+	bs.requests = nil
+	bs.mu.Unlock()
+
+	if _, err := s.HandleBookStackEvent("wrong-secret", newTestEvent("page_update", 10, 1, "Page")); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("expected an invalid secret, got %v", err)
+	}
+
+	// Every event restarts the debounce time
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		jobs, err := s.HandleBookStackEvent("hook-secret", newTestEvent("page_update", 10+i, 1, "Page"))
+		if err != nil || len(jobs) != 1 || jobs[0] != "wiki" {
+			t.Fatalf("expected the job 'wiki', got %v (%v)", jobs, err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	if runs := s.Runs(jobID); len(runs) != 1 {
+		t.Fatalf("the job was executed before the debounce time elapsed: %+v", runs)
+	}
+
+	runs := waitForRuns(t, s, jobID, 2)
+	if len(runs) != 2 || runs[0].Trigger != "webhook" || runs[0].Status != RunSucceeded {
+		t.Fatalf("expected a succeeded run of the webhook, got %+v", runs)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if runs := s.Runs(jobID); len(runs) != 2 {
+		t.Errorf("expected a single run for all events, got %d webhook runs", len(runs)-1)
+	}
+
+	// Only the changed book is exported
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	exports := []string{}
+	for _, path := range bs.requests {
+		if strings.Contains(path, "/export/") {
+			exports = append(exports, path)
+		}
+	}
+	if len(exports) != 1 || exports[0] != "/api/books/1/export/html" {
+		t.Errorf("expected only the export of book 1, got %v", exports)
+	}
+}
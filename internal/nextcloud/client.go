@@ -0,0 +1,277 @@
+package nextcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/metrics"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+var (
+	// Returned when the requested file or directory does not exist
+	ErrNotFound = errors.New("file or directory does not exist")
+	// Returned when the credentials are invalid or the user has no access
+	ErrUnauthorized = errors.New("access denied")
+	// Returned when the file is locked by another process
+	ErrLocked = errors.New("file is locked")
+)
+
+// Returned when the server responded with an unexpected status code.
+// It can be compared with errors.Is to ErrNotFound, ErrUnauthorized and ErrLocked
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// The beginning of the response body
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s %s failed with status code %d", e.Method, e.Path, e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s failed with status code %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrLocked:
+		return e.StatusCode == http.StatusLocked
+	}
+	return false
+}
+
+// Reads the beginning of the body and returns the error for the response
+func newStatusError(res *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return &StatusError{
+		Method:     res.Request.Method,
+		Path:       res.Request.URL.Path,
+		StatusCode: res.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// All clients share the same transport to reuse the connections.
+// The duration of the requests is recorded in the metrics
+var transport = func() http.RoundTripper {
+	rtc := http.DefaultTransport.(*http.Transport).Clone()
+	rtc.MaxIdleConnsPerHost = 16
+	return metrics.InstrumentTransport("nextcloud", rtc)
+}()
+
+// Client to access the files of a single nextcloud user or of a generic WebDAV server
+type Client struct {
+	baseURL  string
+	username string
+	password string
+	// Path of the WebDAV root relative to the base URL (remote.php/dav/files/myUser/)
+	filesPath string
+	// If the server is a generic WebDAV server without the extensions of nextcloud
+	generic bool
+
+	http *http.Client
+	// Timeout of a single request
+	timeout time.Duration
+	// Timeout for uploading and downloading files
+	transferTimeout time.Duration
+	// Maximum count of retries for temporary errors
+	retries int
+
+	// Files larger than this size (in bytes) are uploaded in chunks. A negative value disables chunked uploads
+	chunkThreshold int64
+	// Size of a single chunk in bytes
+	chunkSize int64
+
+	// How the files of a directory are listed
+	listing models.ListingStrategy
+	// Set to 1 when the server does not support WebDAV SEARCH
+	searchUnsupported int32
+}
+
+func NewClient(ncUser *models.NextcloudUser) *Client {
+	rtc := newClient(strings.TrimSuffix(ncUser.NextcloudBaseUrl, "/"), ncUser.Username, string(ncUser.Password), ncUser.Client)
+	rtc.filesPath = "remote.php/dav/files/" + ncUser.Username + "/"
+
+	return rtc
+}
+
+// Returns a client for a generic WebDAV server (e.g. Apache mod_dav or a NAS).
+// The url of the storage is used as the root directory.
+// The extensions of nextcloud like the search, file IDs and chunked uploads are not used
+func NewWebDAVClient(config models.Storage, options models.NextcloudClient) (*Client, error) {
+	root, err := url.Parse(config.URL)
+	if err != nil || root.Scheme == "" || root.Host == "" {
+		return nil, fmt.Errorf("invalid url of the WebDAV server given: '%s'", config.URL)
+	}
+
+	rtc := newClient(root.Scheme+"://"+root.Host, config.Username, string(config.Password), options)
+	rtc.filesPath = strings.Trim(root.Path, "/") + "/"
+	if rtc.filesPath == "/" {
+		rtc.filesPath = ""
+	}
+	rtc.generic = true
+	rtc.listing = models.PropfindListing
+	rtc.chunkThreshold = -1
+
+	return rtc, nil
+}
+
+func newClient(baseURL string, username string, password string, options models.NextcloudClient) *Client {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	transferTimeout := options.TransferTimeout
+	if transferTimeout <= 0 {
+		transferTimeout = 600
+	}
+	retries := options.Retries
+	if retries == 0 {
+		retries = 3
+	} else if retries < 0 {
+		retries = 0
+	}
+	chunkThreshold := int64(options.ChunkThreshold)
+	if chunkThreshold == 0 {
+		chunkThreshold = 20
+	}
+	// Nextcloud requires a minimum size of 5 MiB for all chunks except the last one
+	chunkSize := int64(options.ChunkSize)
+	if chunkSize < 5 {
+		chunkSize = 10
+	}
+
+	return &Client{
+		baseURL:         baseURL,
+		username:        username,
+		password:        password,
+		http:            &http.Client{Transport: transport},
+		timeout:         time.Duration(timeout) * time.Second,
+		transferTimeout: time.Duration(transferTimeout) * time.Second,
+		retries:         retries,
+		chunkThreshold:  chunkThreshold << 20,
+		chunkSize:       chunkSize << 20,
+		listing:         options.Listing,
+	}
+}
+
+// Returns the URL of the server
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Returns true if the server is a nextcloud instance and not a generic WebDAV server
+func (c *Client) IsNextcloud() bool {
+	return !c.generic
+}
+
+// Returns the prefix of the hrefs in the responses of the server
+// until the WebDAV root (/remote.php/dav/files/myUser/)
+func (c *Client) HrefPrefix() string {
+	base, _ := url.Parse(c.baseURL)
+	return strings.TrimSuffix(base.Path, "/") + "/" + c.filesPath
+}
+
+// Returns a new request to the Nexcloud API.
+// The unescaped path beginning AFTER the base URL should be given (e.g.: remote.php/dav/files/myUser/file.txt)
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	escapedPath := url.URL{Path: "/" + path}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+escapedPath.EscapedPath(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	return req, nil
+}
+
+// Cancels the context of the request when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Sends the request created by the given function. When the server is temporarily
+// not available (423, 429 and 5xx) or the connection failed, the request is retried
+// with an exponential backoff.
+// Every attempt is canceled after the timeout. The body of the response has to be closed
+func (c *Client) do(ctx context.Context, timeout time.Duration, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	return c.doWithRetries(ctx, timeout, c.retries, newRequest)
+}
+
+// Sends the request like do with the given maximum count of retries
+func (c *Client) doWithRetries(ctx context.Context, timeout time.Duration, retries int, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		req, err := newRequest(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		res, err := c.http.Do(req)
+		if attempt >= retries || ctx.Err() != nil || (err == nil && !isTemporary(res.StatusCode)) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+
+		wait := time.Second << attempt
+		if err == nil {
+			if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && retryAfter > 0 {
+				wait = time.Duration(retryAfter) * time.Second
+			}
+		}
+		if wait > 30*time.Second {
+			wait = 30 * time.Second
+		}
+
+		if err != nil {
+			logger.Warning("%s %s failed: %s → retrying in %s", req.Method, req.URL.Path, err, wait)
+		} else {
+			logger.Warning("%s %s failed with status code %d → retrying in %s", req.Method, req.URL.Path, res.StatusCode, wait)
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Returns true if the request should be retried for the status code.
+// Methods that are not supported by the server (501) won't succeed on a retry
+func isTemporary(statusCode int) bool {
+	if statusCode == http.StatusNotImplemented {
+		return false
+	}
+	return statusCode == http.StatusLocked || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
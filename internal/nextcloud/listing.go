@@ -0,0 +1,134 @@
+package nextcloud
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+// Maximum count of directories that are listed at the same time with PROPFIND
+const maxParallelListings = 4
+
+// Searches for all files of the given content type starting in the given directory.
+// If recursive is false, only the files directly inside the directory are returned.
+// If the directory does not exist ErrNotFound is returned.
+//
+// The WebDAV SEARCH of nextcloud is used by default. When the server does not support it,
+// the directories are walked with PROPFIND requests instead
+func (c *Client) SearchInDirectory(ctx context.Context, directory string, contentType []string, recursive bool) (*searchResult, error) {
+	if c.listing == models.PropfindListing || atomic.LoadInt32(&c.searchUnsupported) == 1 {
+		return c.walkDirectory(ctx, directory, contentType, recursive)
+	}
+
+	result, err := c.search(ctx, directory, contentType, recursive)
+	var statusErr *StatusError
+	if c.listing != models.SearchListing && errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusNotImplemented || statusErr.StatusCode == http.StatusMethodNotAllowed) {
+		logger.Info("The server does not support WebDAV SEARCH (%d) → using PROPFIND to list the files", statusErr.StatusCode)
+		atomic.StoreInt32(&c.searchUnsupported, 1)
+		return c.walkDirectory(ctx, directory, contentType, recursive)
+	}
+
+	return result, err
+}
+
+// Lists all files of the given content type by walking the directories with depth 1 PROPFIND
+// requests. The result has the same format as the one of a search
+func (c *Client) walkDirectory(ctx context.Context, directory string, contentType []string, recursive bool) (*searchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mut      sync.Mutex
+		firstErr error
+		rtc      = &searchResult{}
+	)
+	limit := make(chan struct{}, maxParallelListings)
+	prefix := c.HrefPrefix()
+
+	var list func(dir string)
+	list = func(dir string) {
+		defer wg.Done()
+
+		limit <- struct{}{}
+		result, err := c.propfind(ctx, dir, "1")
+		<-limit
+
+		mut.Lock()
+		defer mut.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			return
+		}
+
+		for _, response := range result.Response {
+			prop := response.GetProp()
+			if prop.Resourcetype.Collection != nil {
+				subDir := strings.TrimPrefix(getHrefPath(response.Href), prefix)
+				// The directory itself is also contained in the response
+				if recursive && strings.Trim(subDir, "/") != strings.Trim(dir, "/") {
+					wg.Add(1)
+					go list(subDir)
+				}
+				continue
+			}
+
+			// Not all WebDAV servers return a content type
+			if prop.Getcontenttype == "" {
+				prop.Getcontenttype = guessContentType(getHrefPath(response.Href))
+			}
+			if containsContentType(contentType, prop.Getcontenttype) {
+				rtc.Response = append(rtc.Response, response)
+			}
+		}
+	}
+
+	wg.Add(1)
+	go list(directory)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return rtc, nil
+}
+
+// Returns the content type for the extension of the file
+func guessContentType(file string) string {
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(file), "."))
+	if types, exists := models.OfficeContentTypes[extension]; exists {
+		return types[0]
+	}
+	for _, format := range models.OfficeFormats {
+		if format.Extension() == extension {
+			return format.ContentType()
+		}
+	}
+
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension("." + extension))
+	return contentType
+}
+
+// Returns true if the content type (without parameters like the charset) is contained in the given types
+func containsContentType(types []string, contentType string) bool {
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package nextcloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"golang.org/x/net/webdav"
+)
+
+func TestSearchFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		// Status code of the server for SEARCH requests
+		status  int
+		listing models.ListingStrategy
+		// If the files are listed with PROPFIND after the SEARCH failed
+		wantFallback bool
+	}{
+		{name: "not implemented", status: http.StatusNotImplemented, wantFallback: true},
+		{name: "method not allowed", status: http.StatusMethodNotAllowed, wantFallback: true},
+		{name: "search only", status: http.StatusNotImplemented, listing: models.SearchListing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := webdav.NewMemFS()
+			writeMemFile(t, fs, "/docs/a.pdf", "a")
+			handler := &webdav.Handler{Prefix: "/remote.php/dav/files/user", FileSystem: fs, LockSystem: webdav.NewMemLS()}
+
+			var searches int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "SEARCH" {
+					atomic.AddInt32(&searches, 1)
+					w.WriteHeader(tt.status)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			t.Cleanup(srv.Close)
+
+			client := NewClient(&models.NextcloudUser{NextcloudBaseUrl: srv.URL, Username: "user", Client: models.NextcloudClient{Listing: tt.listing}})
+			for i := 0; i < 2; i++ {
+				result, err := client.SearchInDirectory(context.Background(), "docs/", []string{"application/pdf"}, true)
+				if !tt.wantFallback {
+					if err == nil {
+						t.Fatal("expected an error")
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if files := ParseSearchResultFiles(result, client.HrefPrefix()); len(files) != 1 || files[0].Path != "docs/a.pdf" {
+					t.Errorf("expected the file docs/a.pdf, got %v", files)
+				}
+			}
+
+			// Unsupported methods are not retried and SEARCH is not used again after the fallback
+			wantSearches := int32(1)
+			if !tt.wantFallback {
+				wantSearches = 2
+			}
+			if got := atomic.LoadInt32(&searches); got != wantSearches {
+				t.Errorf("SEARCH requests: got %d, want %d", got, wantSearches)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package nextcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A started login flow (v2) of nextcloud. The user has to open the login URL
+// in the browser and grant the access
+type LoginFlow struct {
+	// URL to open in the browser
+	LoginURL string
+
+	server   string
+	token    string
+	endpoint string
+}
+
+// The app password that was created by the login flow
+type AppPassword struct {
+	Server      string `json:"server"`
+	LoginName   string `json:"loginName"`
+	AppPassword string `json:"appPassword"`
+}
+
+// Starts the login flow (v2) on the given nextcloud server
+func StartLoginFlow(ctx context.Context, server string) (*LoginFlow, error) {
+	server = strings.TrimSuffix(server, "/")
+	client := http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/index.php/login/v2", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ncDocConverter")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, newStatusError(res)
+	}
+
+	var result struct {
+		Poll struct {
+			Token    string `json:"token"`
+			Endpoint string `json:"endpoint"`
+		} `json:"poll"`
+		Login string `json:"login"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	return &LoginFlow{
+		LoginURL: result.Login,
+		server:   server,
+		token:    result.Poll.Token,
+		endpoint: result.Poll.Endpoint,
+	}, nil
+}
+
+// Polls the server in the given interval until the user granted the access.
+// The login flow expires after 20 minutes
+func (f *LoginFlow) Wait(ctx context.Context, interval time.Duration) (*AppPassword, error) {
+	client := http.Client{Transport: transport, Timeout: 30 * time.Second}
+	form := url.Values{}
+	form.Add("token", f.token)
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch res.StatusCode {
+		case 200:
+			var rtc AppPassword
+			err := json.NewDecoder(res.Body).Decode(&rtc)
+			res.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode response: %s", err)
+			}
+			return &rtc, nil
+		case 404:
+			// The access was not granted yet
+			res.Body.Close()
+		default:
+			defer res.Body.Close()
+			return nil, newStatusError(res)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Deletes the app password of the user so that it can't be used anymore
+func RevokeAppPassword(ctx context.Context, server string, loginName string, appPassword string) error {
+	client := http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, strings.TrimSuffix(server, "/")+"/ocs/v2.php/core/apppassword", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(loginName, appPassword)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return newStatusError(res)
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package nextcloud
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -14,7 +16,6 @@ import (
 	"time"
 
 	"git.rpjosh.de/RPJosh/go-logger"
-	"git.rpjosh.de/ncDocConverter/internal/models"
 	"git.rpjosh.de/ncDocConverter/web"
 )
 
@@ -28,16 +29,28 @@ type NcFile struct {
 	ContentType  string
 	// Size in Bytes
 	Size int
-	// The unique file ID of the nextcloud server
+	// The unique file ID of the nextcloud server (0 for generic WebDAV servers)
 	Fileid int
+	// Changes whenever the content of the file changes
+	ETag string
 	// The Webdav URL for file reference
 	WebdavURL string
 }
 
+// Returns the unique ID of the file. Generic WebDAV servers have no file IDs → the path is used instead.
+// The ETag can't be used because it changes with every modification
+func (f *NcFile) ID() string {
+	if f.Fileid == 0 {
+		return f.Path
+	}
+	return strconv.Itoa(f.Fileid)
+}
+
 type searchTemplateData struct {
 	Username    string
 	Directory   string
 	ContentType []string
+	Depth       string
 }
 
 type searchResult struct {
@@ -50,24 +63,43 @@ type searchResult struct {
 	Response []searchResultResponse `xml:"response"`
 }
 type searchResultResponse struct {
-	Text     string `xml:",chardata"`
-	Href     string `xml:"href"`
-	Propstat struct {
-		Text string `xml:",chardata"`
-		Prop struct {
-			Text            string `xml:",chardata"`
-			Getcontenttype  string `xml:"getcontenttype"`
-			Getlastmodified string `xml:"getlastmodified"`
-			Size            string `xml:"size"`
-			Fileid          int    `xml:"fileid"`
-		} `xml:"prop"`
-		Status string `xml:"status"`
-	} `xml:"propstat"`
+	Text     string                 `xml:",chardata"`
+	Href     string                 `xml:"href"`
+	Propstat []searchResultPropstat `xml:"propstat"`
+}
+type searchResultPropstat struct {
+	Text   string           `xml:",chardata"`
+	Prop   searchResultProp `xml:"prop"`
+	Status string           `xml:"status"`
+}
+type searchResultProp struct {
+	Text             string `xml:",chardata"`
+	Getcontenttype   string `xml:"getcontenttype"`
+	Getlastmodified  string `xml:"getlastmodified"`
+	Size             string `xml:"size"`
+	Fileid           int    `xml:"fileid"`
+	Getetag          string `xml:"getetag"`
+	Getcontentlength string `xml:"getcontentlength"`
+	Resourcetype     struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
+}
+
+// Returns the properties that were found. Properties that are not
+// supported by the server are returned in an extra propstat with the status 404
+func (r *searchResultResponse) GetProp() *searchResultProp {
+	for i, propstat := range r.Propstat {
+		if strings.Contains(propstat.Status, " 200 ") {
+			return &r.Propstat[i].Prop
+		}
+	}
+
+	return &searchResultProp{}
 }
 
 func (r *searchResultResponse) GetLastModified() time.Time {
 	// Time format: Fri, 23 Sep 2022 05:46:31 GMT
-	rtc, err := time.Parse("Mon, 02 Jan 2006 15:04:05 GMT", r.Propstat.Prop.Getlastmodified)
+	rtc, err := time.Parse("Mon, 02 Jan 2006 15:04:05 GMT", r.GetProp().Getlastmodified)
 	if err != nil {
 		logger.Warning("%s", err)
 		rtc = time.Unix(0, 1)
@@ -76,130 +108,199 @@ func (r *searchResultResponse) GetLastModified() time.Time {
 	return rtc
 }
 
-// Returns a new request to the Nexcloud API.
-// The path beginning AFTER /dav/ should be given (e.g.: myUser/folder/file.txt)
-func getRequest(method string, path string, body io.Reader, ncUser *models.NextcloudUser) *http.Request {
-	req, err := http.NewRequest(method, ncUser.NextcloudBaseUrl+"/remote.php/dav/"+path, body)
-	if err != nil {
-		logger.Error("%s", err)
-	}
-	req.SetBasicAuth(ncUser.Username, ncUser.Password)
-
-	return req
+// Returns the WebDAV path of the file relative to the base URL
+func (c *Client) getFilePath(filePath string) string {
+	return c.filesPath + strings.TrimPrefix(filePath, "/")
 }
 
-// Searches for all files of the given content type starting in the given directory.
-func SearchInDirectory(ncUser *models.NextcloudUser, directory string, contentType []string) (*searchResult, error) {
-	client := http.Client{Timeout: 5 * time.Second}
+// Returns the escaped URL of the file for the Destination header
+func (c *Client) getDestinationURL(filePath string) string {
+	destinationURL := url.URL{Path: "/" + c.getFilePath(filePath)}
+	return c.baseURL + destinationURL.EscapedPath()
+}
 
+// Searches with the WebDAV SEARCH method of nextcloud for all files of the given content type
+func (c *Client) search(ctx context.Context, directory string, contentType []string, recursive bool) (*searchResult, error) {
 	template, err := template.ParseFS(web.ApiTemplateFiles, "apitemplate/ncsearch.tmpl.xml")
 	if err != nil {
 		return nil, err
 	}
 	var buf bytes.Buffer
 	templateData := searchTemplateData{
-		Username:    ncUser.Username,
+		Username:    c.username,
 		Directory:   directory,
 		ContentType: contentType,
+		Depth:       "infinity",
+	}
+	if !recursive {
+		templateData.Depth = "1"
 	}
 	if err = template.Execute(&buf, templateData); err != nil {
 		return nil, err
 	}
 
 	// Status code 207
-	req := getRequest("SEARCH", "", &buf, ncUser)
-	req.Header.Set("Content-Type", "application/xml")
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, "SEARCH", "remote.php/dav/", bytes.NewReader(buf.Bytes()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/xml")
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 207 {
+		return nil, newStatusError(res)
+	}
 
-	res, err := client.Do(req)
+	var result searchResult
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	return &result, nil
+}
+
+// Returns the properties of a single file with the given path.
+// The path has to start at the root level: Ebook/myFolder/file.txt
+// If the file does not exist ErrNotFound is returned
+func (c *Client) GetFileInfo(ctx context.Context, filePath string) (*NcFile, error) {
+	result, err := c.propfind(ctx, filePath, "0")
 	if err != nil {
 		return nil, err
 	}
 
-	// Decody body first before checking status code to print in error message
-	defer res.Body.Close()
+	for _, file := range ParseSearchResult(result, c.HrefPrefix(), "") {
+		return &file, nil
+	}
+
+	return nil, fmt.Errorf("no properties returned for file %s", filePath)
+}
 
-	resBody, err := io.ReadAll(res.Body)
+// Returns the properties of the file or directory with the given path.
+// With the depth "1" the properties of all files inside the directory are also returned
+func (c *Client) propfind(ctx context.Context, filePath string, depth string) (*searchResult, error) {
+	template, err := template.ParseFS(web.ApiTemplateFiles, "apitemplate/ncpropfind.tmpl.xml")
 	if err != nil {
 		return nil, err
 	}
+	var buf bytes.Buffer
+	if err = template.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
 
-	// Create folder if not existing
-	if res.StatusCode == 404 {
-		logger.Info("Creating directory '%s' because it does not exist", "/"+directory)
-		CreateFoldersRecursively(ncUser, "/"+directory+"notExisting.pdf")
-		return &searchResult{}, nil
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, "PROPFIND", c.getFilePath(filePath), bytes.NewReader(buf.Bytes()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/xml")
+			req.Header.Set("Depth", depth)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 207 {
-		return nil, fmt.Errorf("status code %d: %s", res.StatusCode, resBody)
+		return nil, newStatusError(res)
 	}
 
 	var result searchResult
-	if err = xml.Unmarshal(resBody, &result); err != nil {
-		return nil, err
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
 	}
 
 	return &result, nil
 }
 
 // Parses the response from the given search format to an NcFile.
-// A map with the relative path based on the source Directory ("someFolder/file.txt")
+// A map with the relative path based on the source Directory without the file extension ("someFolder/file")
 // and the mathing NcFile will be returned. Therefore, also the source Directory has to be given.
 //
 // To determine the path without the prefix "/remote.php/dav/user/" it has to be given.
 func ParseSearchResult(result *searchResult, prefix string, sourceDir string) map[string]NcFile {
-	preCount := len(prefix)
 	rtc := make(map[string]NcFile)
 
+	for _, file := range ParseSearchResultFiles(result, prefix) {
+		var name = file.Path[0 : len(file.Path)-len(file.Extension)][len(sourceDir):]
+		rtc[name] = file
+	}
+
+	return rtc
+}
+
+// Parses the response from the given search format to a list of NcFiles.
+// In contrast to ParseSearchResult files with the same name but a different
+// extension are all contained.
+//
+// To determine the path without the prefix "/remote.php/dav/user/" it has to be given.
+func ParseSearchResultFiles(result *searchResult, prefix string) []NcFile {
+	preCount := len(prefix)
+	rtc := make([]NcFile, 0, len(result.Response))
+
 	for _, file := range result.Response {
-		href, _ := url.QueryUnescape(file.Href)
+		href := getHrefPath(file.Href)
+		if !strings.HasPrefix(href, prefix) {
+			logger.Warning("Ignoring file %s outside of the WebDAV root %s", href, prefix)
+			continue
+		}
 		path := href[preCount:]
 		var extension = filepath.Ext(path)
-		var name = path[0 : len(path)-len(extension)][len(sourceDir):]
 		time := file.GetLastModified()
-		size, err := strconv.Atoi(file.Propstat.Prop.Size)
+		prop := file.GetProp()
+
+		// Plain WebDAV servers do not know the size property of owncloud
+		sizeProp := prop.Size
+		if sizeProp == "" {
+			sizeProp = prop.Getcontentlength
+		}
+		size, err := strconv.Atoi(sizeProp)
 		if err != nil {
-			logger.Error("Failed to parse the file size '%s' to an integer: %s", file.Propstat.Prop.Size, err)
+			logger.Error("Failed to parse the file size '%s' to an integer: %s", sizeProp, err)
 			continue
 		}
-		rtc[name] = NcFile{
+		rtc = append(rtc, NcFile{
 			Extension:    extension,
 			Path:         path,
 			LastModified: time,
 			Size:         size,
-			ContentType:  file.Propstat.Prop.Getcontenttype,
-			Fileid:       file.Propstat.Prop.Fileid,
+			ContentType:  prop.Getcontenttype,
+			Fileid:       prop.Fileid,
+			ETag:         strings.Trim(prop.Getetag, "\""),
 			WebdavURL:    file.Href,
-		}
+		})
 	}
 
 	return rtc
 }
 
-// Delets a file with the given path.
-// The path has to start at the root level: Ebook/myFolder/file.txt
-func DeleteFile(ncUser *models.NextcloudUser, filePath string) error {
-	return deleteFile(ncUser, filePath, true)
-}
-func deleteFile(ncUser *models.NextcloudUser, filePath string, retry bool) error {
-	client := http.Client{Timeout: 5 * time.Second}
+// Returns the unescaped path of the href. Some WebDAV servers return absolute URLs
+func getHrefPath(href string) string {
+	if u, err := url.Parse(href); err == nil && u.IsAbs() {
+		href = u.EscapedPath()
+	}
+	rtc, _ := url.PathUnescape(href)
 
-	req := getRequest(http.MethodDelete, "files/"+ncUser.Username+"/"+filePath, nil, ncUser)
+	return rtc
+}
 
-	res, err := client.Do(req)
+// Delets a file with the given path.
+// The path has to start at the root level: Ebook/myFolder/file.txt
+func (c *Client) DeleteFile(ctx context.Context, filePath string) error {
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		return c.newRequest(ctx, http.MethodDelete, c.getFilePath(filePath), nil)
+	})
 	if err != nil {
-		logger.Error("%s", err)
+		return fmt.Errorf("failed to delete file %s: %s", filePath, err)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 204 {
-		return fmt.Errorf("failed to delete file %s (%d)", filePath, res.StatusCode)
-	}
-
-	// If the server is locked try to delete it again
-	if res.StatusCode == 423 && retry {
-		logger.Debug("Trying to delete the file again (it was locked previously)")
-		time.Sleep(10 * time.Millisecond)
-		return deleteFile(ncUser, filePath, false)
+		return fmt.Errorf("failed to delete file %s: %w", filePath, newStatusError(res))
 	}
 
 	return nil
@@ -207,45 +308,121 @@ func deleteFile(ncUser *models.NextcloudUser, filePath string, retry bool) error
 
 // Creates all required directorys to create the destination file recursively.
 // The path should be relative to the root: ebook/folder1/folder2/file.txt
-func CreateFoldersRecursively(ncUser *models.NextcloudUser, destinationFile string) {
-	s := strings.Split(destinationFile, "/")
+func (c *Client) CreateFoldersRecursively(ctx context.Context, destinationFile string) error {
+	s := strings.Split(strings.TrimPrefix(destinationFile, "/"), "/")
 	folderTree := ""
 
 	// Webdav doesn't have a function to create directories recursively → iterate
 	for _, folder := range s[:len(s)-1] {
 		folderTree += folder + "/"
 
-		client := http.Client{Timeout: 5 * time.Second}
-		req := getRequest("MKCOL", "files/"+ncUser.Username+"/"+folderTree, nil, ncUser)
-
-		res, err := client.Do(req)
+		res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+			return c.newRequest(ctx, "MKCOL", c.getFilePath(folderTree), nil)
+		})
 		if err != nil {
-			logger.Error("%s", err)
+			return fmt.Errorf("failed to create directory '%s': %s", folderTree, err)
 		}
+		res.Body.Close()
 
+		// 405: the directory does already exist
 		if res.StatusCode != 201 && res.StatusCode != 405 {
-			logger.Error("Failed to create directory '%s'", folderTree)
+			return fmt.Errorf("failed to create directory '%s': %w", folderTree, newStatusError(res))
+		}
+	}
+
+	return nil
+}
+
+// Returns a direct download link for the file with the given ID.
+// The link can be accessed without authentication for eight hours.
+func (c *Client) GetDirectDownloadURL(ctx context.Context, fileid int) (string, error) {
+	if !c.IsNextcloud() {
+		return "", fmt.Errorf("direct download links are only supported by nextcloud")
+	}
+
+	form := url.Values{}
+	form.Add("fileId", strconv.Itoa(fileid))
+
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPost, "ocs/v2.php/apps/dav/api/v1/direct", strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("OCS-APIRequest", "true")
 		}
+		return req, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return "", newStatusError(res)
+	}
+
+	var result struct {
+		Ocs struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		} `json:"ocs"`
 	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	return result.Ocs.Data.URL, nil
 }
 
-// Uploads a file to the nextcloud server.
-// It will be saved to the destination as a relative path to the nextcloud root (ebook/file.txt).
-func UploadFile(ncUser *models.NextcloudUser, destination string, content io.ReadCloser) error {
-	client := http.Client{Timeout: 10 * time.Second}
-	cnt, err := io.ReadAll(content)
+// Downloads the file with the given path.
+// The path has to start at the root level: Ebook/myFolder/file.txt
+// The returned content has to be closed by the caller.
+func (c *Client) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	res, err := c.Get(ctx, c.getFilePath(filePath), nil)
 	if err != nil {
-		return fmt.Errorf("failed to read body: %s", err)
+		return nil, fmt.Errorf("failed to download file %s: %s", filePath, err)
 	}
-	req := getRequest(http.MethodPut, "files/"+ncUser.Username+"/"+destination, bytes.NewBuffer(cnt), ncUser)
 
-	res, err := client.Do(req)
+	if res.StatusCode != 200 {
+		defer res.Body.Close()
+		return nil, fmt.Errorf("failed to download file %s: %w", filePath, newStatusError(res))
+	}
+
+	return res.Body, nil
+}
+
+// Sends a GET request with the credentials of the user to the given path relative
+// to the base URL of nextcloud (apps/onlyoffice/downloadas). The timeout for transfers is used.
+// The body of the response has to be closed by the caller
+func (c *Client) Get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	return c.do(ctx, c.transferTimeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+		if err == nil {
+			req.URL.RawQuery = query.Encode()
+		}
+		return req, err
+	})
+}
+
+// Moves or renames a file. An existing file at the destination will be overwritten.
+// Both paths have to start at the root level: Ebook/myFolder/file.txt
+func (c *Client) MoveFile(ctx context.Context, source string, destination string) error {
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, "MOVE", c.getFilePath(source), nil)
+		if err == nil {
+			req.Header.Set("Destination", c.getDestinationURL(destination))
+			req.Header.Set("Overwrite", "T")
+		}
+		return req, err
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to move file %s to %s: %s", source, destination, err)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 201 && res.StatusCode != 204 {
-		return fmt.Errorf("expected status code 201 or 204 but got %d", res.StatusCode)
+		return fmt.Errorf("failed to move file %s to %s: %w", source, destination, newStatusError(res))
 	}
 
 	return nil
@@ -0,0 +1,301 @@
+package nextcloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"git.rpjosh.de/RPJosh/go-logger"
+)
+
+// Content of the uploads collection of a chunked upload
+type chunkList struct {
+	Response []struct {
+		Href string `xml:"href"`
+		Size string `xml:"propstat>prop>getcontentlength"`
+	} `xml:"response"`
+}
+
+// Uploads a file to the nextcloud server.
+// It will be saved to the destination as a relative path to the nextcloud root (ebook/file.txt).
+//
+// When the size of the content is known, it's streamed directly to the server. Otherwise (-1)
+// it's written to a temporary file first to determine the size. Files larger than the chunk threshold
+// are uploaded in chunks. The key identifies the uploader and the version of the content (e.g. the job
+// and the ETag of the source), so that an interrupted chunked upload is only resumed for the same content
+func (c *Client) UploadFile(ctx context.Context, destination string, content io.Reader, size int64, key string) error {
+	if size < 0 {
+		tmp, err := os.CreateTemp("", "ncDocConverter-*.upload")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if size, err = io.Copy(tmp, content); err != nil {
+			return fmt.Errorf("failed to read body: %s", err)
+		}
+		content = io.NewSectionReader(tmp, 0, size)
+	}
+	source := &sourceReader{reader: content}
+
+	if c.chunkThreshold < 0 || size <= c.chunkThreshold {
+		err := c.putFile(ctx, destination, newUploadBody(source, 0, size))
+		// Generic WebDAV servers may keep the truncated file. Nextcloud discards incomplete uploads itself
+		if err != nil && (ctx.Err() != nil || source.err != nil) && c.generic {
+			c.removeIncompleteUpload(c.getFilePath(destination))
+		}
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(key + "\n" + destination + "\n" + strconv.FormatInt(size, 10)))
+	id := "ncDocConverter-" + hex.EncodeToString(hash[:])[0:32]
+	err := c.uploadChunked(ctx, destination, source, size, id)
+	// The upload can't be resumed when the program is shutting down
+	if err != nil && ctx.Err() != nil {
+		c.removeIncompleteUpload(c.getUploadDir(id))
+	}
+	return err
+}
+
+// Remembers the first error of the reader to distinguish errors of the source from the ones of the server
+type sourceReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// The part of the content that is sent with a single request
+type uploadBody struct {
+	source *sourceReader
+	// Set if the content can be read at any offset (temporary file)
+	readerAt io.ReaderAt
+	offset   int64
+	length   int64
+}
+
+func newUploadBody(source *sourceReader, offset int64, length int64) *uploadBody {
+	readerAt, _ := source.reader.(io.ReaderAt)
+	return &uploadBody{source: source, readerAt: readerAt, offset: offset, length: length}
+}
+
+// Returns a reader for a new attempt of the request
+func (b *uploadBody) open() io.Reader {
+	if b.readerAt != nil {
+		return io.NewSectionReader(b.readerAt, b.offset, b.length)
+	}
+	return io.LimitReader(b.source, b.length)
+}
+
+// Streamed content can only be sent once → the request can't be retried
+func (b *uploadBody) retries(c *Client) int {
+	if b.readerAt != nil {
+		return c.retries
+	}
+	return 0
+}
+
+// Skips the content, e.g. because it was already uploaded
+func (b *uploadBody) skip() error {
+	if b.readerAt != nil {
+		return nil
+	}
+
+	_, err := io.CopyN(io.Discard, b.source, b.length)
+	return err
+}
+
+// Deletes the remains of an upload that was canceled
+func (c *Client) removeIncompleteUpload(target string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodDelete, target, nil)
+	if err != nil {
+		return
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		logger.Warning("Failed to remove the incomplete upload %s: %s", target, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 204 && res.StatusCode != 404 {
+		logger.Warning("Failed to remove the incomplete upload %s: %s", target, newStatusError(res))
+		return
+	}
+	logger.Debug("Removed the incomplete upload %s", target)
+}
+
+// Uploads the file with a single PUT request
+func (c *Client) putFile(ctx context.Context, destination string, body *uploadBody) error {
+	res, err := c.doWithRetries(ctx, c.transferTimeout, body.retries(c), func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPut, c.getFilePath(destination), body.open())
+		if err == nil {
+			req.ContentLength = body.length
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 201 && res.StatusCode != 204 {
+		return newStatusError(res)
+	}
+
+	return nil
+}
+
+// Uploads the file with the chunked upload (v2) of nextcloud.
+// The chunks are saved inside a folder of the uploads collection and are assembled
+// by a final MOVE. Chunks that were already uploaded by a previous attempt are skipped.
+// Incomplete uploads are removed by nextcloud after 24 hours
+func (c *Client) uploadChunked(ctx context.Context, destination string, source *sourceReader, size int64, id string) error {
+	uploadDir := c.getUploadDir(id)
+	headers := map[string]string{
+		"Destination":     c.getDestinationURL(destination),
+		"OC-Total-Length": strconv.FormatInt(size, 10),
+	}
+
+	existing, err := c.getUploadedChunks(ctx, uploadDir)
+	if err != nil {
+		return fmt.Errorf("failed to get the state of the chunked upload: %s", err)
+	}
+	if existing == nil {
+		if err := c.sendUploadRequest(ctx, "MKCOL", uploadDir, nil, headers); err != nil {
+			return fmt.Errorf("failed to start the chunked upload: %s", err)
+		}
+	} else {
+		logger.Debug("Resuming chunked upload of %s (%d chunks already uploaded)", destination, len(existing))
+	}
+
+	for chunk, offset := 1, int64(0); offset < size; chunk, offset = chunk+1, offset+c.chunkSize {
+		length := c.chunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		name := fmt.Sprintf("%05d", chunk)
+		body := newUploadBody(source, offset, length)
+		if existing[name] == length {
+			if err := body.skip(); err != nil {
+				return fmt.Errorf("failed to read body: %s", err)
+			}
+			continue
+		}
+
+		if err := c.sendUploadRequest(ctx, http.MethodPut, uploadDir+"/"+name, body, headers); err != nil {
+			return fmt.Errorf("failed to upload chunk %d of %s: %s", chunk, destination, err)
+		}
+	}
+
+	if err := c.sendUploadRequest(ctx, "MOVE", uploadDir+"/.file", nil, headers); err != nil {
+		return fmt.Errorf("failed to assemble the chunks of %s: %s", destination, err)
+	}
+
+	return nil
+}
+
+// Returns the path of the folder that contains the chunks of the upload
+func (c *Client) getUploadDir(id string) string {
+	return "remote.php/dav/uploads/" + c.username + "/" + id
+}
+
+// Sends a request of the chunked upload with the given headers and optional content
+func (c *Client) sendUploadRequest(ctx context.Context, method string, target string, body *uploadBody, headers map[string]string) error {
+	retries := c.retries
+	if body != nil {
+		retries = body.retries(c)
+	}
+
+	res, err := c.doWithRetries(ctx, c.transferTimeout, retries, func(ctx context.Context) (*http.Request, error) {
+		var content io.Reader
+		if body != nil {
+			content = body.open()
+		}
+
+		req, err := c.newRequest(ctx, method, target, content)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if body != nil {
+			req.ContentLength = body.length
+		}
+
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 201 && res.StatusCode != 204 {
+		return newStatusError(res)
+	}
+
+	return nil
+}
+
+// Returns the size of the chunks indexed by their name that were already uploaded.
+// If the upload does not exist, nil is returned
+func (c *Client) getUploadedChunks(ctx context.Context, uploadDir string) (map[string]int64, error) {
+	body := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`
+
+	res, err := c.do(ctx, c.timeout, func(ctx context.Context) (*http.Request, error) {
+		req, err := c.newRequest(ctx, "PROPFIND", uploadDir, strings.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/xml")
+			req.Header.Set("Depth", "1")
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != 207 {
+		return nil, newStatusError(res)
+	}
+
+	var result chunkList
+	if err = xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err)
+	}
+
+	rtc := make(map[string]int64)
+	for _, chunk := range result.Response {
+		size, err := strconv.ParseInt(chunk.Size, 10, 64)
+		if err != nil {
+			// The upload folder itself has no content length
+			continue
+		}
+		rtc[path.Base(chunk.Href)] = size
+	}
+
+	return rtc, nil
+}
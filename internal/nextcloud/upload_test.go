@@ -0,0 +1,177 @@
+package nextcloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+// Implements the chunked upload (v2) of nextcloud in memory
+type fakeChunkServer struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// Chunks of the uploads indexed by the upload directory and the chunk name
+	uploads map[string]map[string]string
+	// Assembled files indexed by their path below the files of the user
+	files map[string]string
+	// Count of uploaded chunks
+	chunkPuts int
+	// The chunk with this number fails once with an internal server error
+	failChunk int
+}
+
+func newFakeChunkServer(t *testing.T) *fakeChunkServer {
+	s := &fakeChunkServer{uploads: make(map[string]map[string]string), files: make(map[string]string)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+func (s *fakeChunkServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	const uploadsPrefix = "/remote.php/dav/uploads/user/"
+	if !strings.HasPrefix(r.URL.Path, uploadsPrefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	dir, chunk := path.Split(strings.TrimPrefix(r.URL.Path, uploadsPrefix))
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir, chunk = chunk, ""
+	}
+
+	switch r.Method {
+	case "MKCOL":
+		s.uploads[dir] = make(map[string]string)
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		chunks, exists := s.uploads[dir]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s%s/</d:href></d:response>`, uploadsPrefix, dir)
+		for name, content := range chunks {
+			fmt.Fprintf(w, `<d:response><d:href>%s%s/%s</d:href><d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength></d:prop></d:propstat></d:response>`,
+				uploadsPrefix, dir, name, len(content))
+		}
+		fmt.Fprint(w, `</d:multistatus>`)
+	case http.MethodPut:
+		chunks, exists := s.uploads[dir]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		if s.failChunk > 0 && chunk == fmt.Sprintf("%05d", s.failChunk) {
+			s.failChunk = 0
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.chunkPuts++
+		chunks[chunk] = string(content)
+		w.WriteHeader(http.StatusCreated)
+	case "MOVE":
+		chunks, exists := s.uploads[dir]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		names := make([]string, 0, len(chunks))
+		for name := range chunks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var content strings.Builder
+		for _, name := range names {
+			content.WriteString(chunks[name])
+		}
+
+		destination, _ := url.Parse(r.Header.Get("Destination"))
+		s.files[strings.TrimPrefix(destination.Path, "/remote.php/dav/files/user/")] = content.String()
+		delete(s.uploads, dir)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(s.uploads, dir)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns a nextcloud client that uploads files larger than 8 bytes in chunks of 4 bytes
+func newChunkTestClient(s *fakeChunkServer) *Client {
+	client := NewClient(&models.NextcloudUser{NextcloudBaseUrl: s.server.URL, Username: "user", Client: models.NextcloudClient{Retries: -1}})
+	client.chunkThreshold = 8
+	client.chunkSize = 4
+
+	return client
+}
+
+func TestChunkedUploadResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// Content of the interrupted upload
+		first string
+		// Content of the second upload
+		second string
+		// If the second upload is a different version of the content
+		changed bool
+		// Count of chunks that are sent by the second upload
+		wantPuts int
+	}{
+		{name: "same content", first: "0123456789abcdef", second: "0123456789abcdef", wantPuts: 3},
+		{name: "changed content with the same size", first: "0123456789abcdef", second: "ABCDEFGHIJKLMNOP", changed: true, wantPuts: 4},
+		{name: "changed size", first: "0123456789abcdef", second: "0123456789abcdefgh", wantPuts: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeChunkServer(t)
+			client := newChunkTestClient(server)
+			ctx := context.Background()
+
+			// The first chunk is uploaded, the second one fails
+			server.failChunk = 2
+			if err := client.UploadFile(ctx, "docs/file.pdf", strings.NewReader(tt.first), int64(len(tt.first)), "job\netag-1"); err == nil {
+				t.Fatal("expected the first upload to fail")
+			}
+			if len(server.files) != 0 {
+				t.Fatalf("the interrupted upload must not be assembled")
+			}
+
+			key := "job\netag-1"
+			if tt.changed {
+				key = "job\netag-2"
+			}
+			server.chunkPuts = 0
+			// The reader is not seekable to test the skipping of uploaded chunks
+			content := io.MultiReader(strings.NewReader(tt.second))
+			if err := client.UploadFile(ctx, "docs/file.pdf", content, int64(len(tt.second)), key); err != nil {
+				t.Fatalf("failed to upload the file: %s", err)
+			}
+
+			if got := server.files["docs/file.pdf"]; got != tt.second {
+				t.Errorf("content: got '%s', want '%s'", got, tt.second)
+			}
+			if server.chunkPuts != tt.wantPuts {
+				t.Errorf("uploaded chunks: got %d, want %d", server.chunkPuts, tt.wantPuts)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package nextcloud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"golang.org/x/net/webdav"
+)
+
+// Starts a WebDAV server with an in-memory filesystem below the path /dav/
+func newWebDAVServer(t *testing.T) (*httptest.Server, webdav.FileSystem) {
+	fs := webdav.NewMemFS()
+	srv := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(srv.Close)
+
+	return srv, fs
+}
+
+func newWebDAVTestClient(t *testing.T, srv *httptest.Server) *Client {
+	client, err := NewWebDAVClient(models.Storage{Type: models.WebDAVStorage, URL: srv.URL + "/dav/"}, models.NextcloudClient{Retries: -1})
+	if err != nil {
+		t.Fatalf("failed to create the client: %s", err)
+	}
+
+	return client
+}
+
+func writeMemFile(t *testing.T, fs webdav.FileSystem, name string, content string) {
+	ctx := context.Background()
+	if dir := name[:strings.LastIndex(name, "/")]; dir != "" {
+		if err := fs.Mkdir(ctx, dir, 0755); err != nil && !os.IsExist(err) {
+			t.Fatalf("failed to create directory %s: %s", dir, err)
+		}
+	}
+
+	f, err := fs.OpenFile(ctx, name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("failed to create file %s: %s", name, err)
+	}
+	defer f.Close()
+	if _, err := io.WriteString(f, content); err != nil {
+		t.Fatalf("failed to write file %s: %s", name, err)
+	}
+}
+
+func readMemFile(t *testing.T, fs webdav.FileSystem, name string) (string, bool) {
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return "", false
+	} else if err != nil {
+		t.Fatalf("failed to open file %s: %s", name, err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("failed to read file %s: %s", name, err)
+	}
+	return string(content), true
+}
+
+func TestNewWebDAVClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		baseURL    string
+		filesPath  string
+		hrefPrefix string
+		wantErr    bool
+	}{
+		{name: "path with slash", url: "https://nas.local/dav/", baseURL: "https://nas.local", filesPath: "dav/", hrefPrefix: "/dav/"},
+		{name: "path without slash", url: "https://nas.local/remote/dav", baseURL: "https://nas.local", filesPath: "remote/dav/", hrefPrefix: "/remote/dav/"},
+		{name: "root", url: "http://nas.local:8080", baseURL: "http://nas.local:8080", filesPath: "", hrefPrefix: "/"},
+		{name: "root with slash", url: "http://nas.local/", baseURL: "http://nas.local", filesPath: "", hrefPrefix: "/"},
+		{name: "without scheme", url: "nas.local/dav", wantErr: true},
+		{name: "empty", url: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewWebDAVClient(models.Storage{Type: models.WebDAVStorage, URL: tt.url}, models.NextcloudClient{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for the url '%s'", tt.url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if client.BaseURL() != tt.baseURL {
+				t.Errorf("base url: got '%s', want '%s'", client.BaseURL(), tt.baseURL)
+			}
+			if client.filesPath != tt.filesPath {
+				t.Errorf("files path: got '%s', want '%s'", client.filesPath, tt.filesPath)
+			}
+			if client.HrefPrefix() != tt.hrefPrefix {
+				t.Errorf("href prefix: got '%s', want '%s'", client.HrefPrefix(), tt.hrefPrefix)
+			}
+			if client.IsNextcloud() {
+				t.Error("a generic WebDAV client must not use the extensions of nextcloud")
+			}
+			if client.listing != models.PropfindListing || client.chunkThreshold >= 0 {
+				t.Errorf("expected PROPFIND listing without chunked uploads, got '%s' and %d", client.listing, client.chunkThreshold)
+			}
+		})
+	}
+}
+
+func TestWebDAVListing(t *testing.T) {
+	srv, fs := newWebDAVServer(t)
+	writeMemFile(t, fs, "/docs/a.pdf", "a")
+	writeMemFile(t, fs, "/docs/C++ notes.pdf", "c++")
+	writeMemFile(t, fs, "/docs/c.html", "<html></html>")
+	writeMemFile(t, fs, "/docs/sub/b.pdf", "bb")
+	writeMemFile(t, fs, "/docs/sub/deeper/d.pdf", "dddd")
+	writeMemFile(t, fs, "/other/e.pdf", "e")
+	client := newWebDAVTestClient(t, srv)
+
+	tests := []struct {
+		name        string
+		directory   string
+		contentType []string
+		recursive   bool
+		want        []string
+		wantErr     error
+	}{
+		{name: "recursive", directory: "docs/", contentType: []string{"application/pdf"}, recursive: true,
+			want: []string{"docs/C++ notes.pdf", "docs/a.pdf", "docs/sub/b.pdf", "docs/sub/deeper/d.pdf"}},
+		{name: "not recursive", directory: "docs/", contentType: []string{"application/pdf"}, want: []string{"docs/C++ notes.pdf", "docs/a.pdf"}},
+		{name: "sub directory", directory: "docs/sub/", contentType: []string{"application/pdf"}, recursive: true,
+			want: []string{"docs/sub/b.pdf", "docs/sub/deeper/d.pdf"}},
+		{name: "content type", directory: "docs/", contentType: []string{"text/html"}, recursive: true, want: []string{"docs/c.html"}},
+		{name: "multiple content types", directory: "docs/", contentType: []string{"text/html", "application/pdf"},
+			want: []string{"docs/C++ notes.pdf", "docs/a.pdf", "docs/c.html"}},
+		{name: "no matches", directory: "docs/", contentType: []string{"text/plain"}, recursive: true, want: []string{}},
+		{name: "missing directory", directory: "missing/", contentType: []string{"application/pdf"}, recursive: true, wantErr: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.SearchInDirectory(context.Background(), tt.directory, tt.contentType, tt.recursive)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected the error '%s', got '%v'", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			files := ParseSearchResultFiles(result, client.HrefPrefix())
+			got := make([]string, 0, len(files))
+			for _, file := range files {
+				got = append(got, file.Path)
+
+				if file.ID() != file.Path {
+					t.Errorf("the ID of %s should be its path, got '%s'", file.Path, file.ID())
+				}
+				if content, _ := readMemFile(t, fs, "/"+file.Path); file.Size != len(content) {
+					t.Errorf("size of %s: got %d, want %d", file.Path, file.Size, len(content))
+				}
+				if file.ETag == "" || file.LastModified.Unix() <= 0 {
+					t.Errorf("missing ETag or modification time of %s", file.Path)
+				}
+
+				// The path of the listing has to address the file
+				body, err := client.DownloadFile(context.Background(), file.Path)
+				if err != nil {
+					t.Errorf("failed to download %s: %s", file.Path, err)
+					continue
+				}
+				downloaded, _ := io.ReadAll(body)
+				body.Close()
+				if content, _ := readMemFile(t, fs, "/"+file.Path); string(downloaded) != content {
+					t.Errorf("content of %s: got '%s', want '%s'", file.Path, downloaded, content)
+				}
+			}
+			sort.Strings(got)
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebDAVUploadFile(t *testing.T) {
+	srv, fs := newWebDAVServer(t)
+	writeMemFile(t, fs, "/existing/file.pdf", "old content")
+	client := newWebDAVTestClient(t, srv)
+
+	large := strings.Repeat("0123456789", 100000)
+	tests := []struct {
+		name        string
+		destination string
+		content     string
+		// Size that is given to the upload (-1 if unknown)
+		size int64
+	}{
+		{name: "known size", destination: "upload/known.pdf", content: "known content", size: 13},
+		{name: "unknown size", destination: "upload/unknown.pdf", content: "unknown content", size: -1},
+		{name: "empty file", destination: "upload/empty.pdf", content: "", size: 0},
+		{name: "large file", destination: "upload/large.pdf", content: large, size: int64(len(large))},
+		{name: "nested directories", destination: "upload/a/b/c/nested.pdf", content: "nested", size: 6},
+		{name: "overwrite", destination: "existing/file.pdf", content: "new content", size: 11},
+		{name: "special characters", destination: "upload/my file #1 (ä) C++.pdf", content: "special", size: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := client.CreateFoldersRecursively(ctx, tt.destination); err != nil {
+				t.Fatalf("failed to create the folders: %s", err)
+			}
+
+			// The reader must not be replayable to test the streamed upload
+			content := io.MultiReader(bytes.NewReader([]byte(tt.content)))
+			if err := client.UploadFile(ctx, tt.destination, content, tt.size, "test"); err != nil {
+				t.Fatalf("failed to upload the file: %s", err)
+			}
+
+			got, exists := readMemFile(t, fs, "/"+tt.destination)
+			if !exists {
+				t.Fatalf("the file %s was not created", tt.destination)
+			}
+			if got != tt.content {
+				t.Errorf("content of %s: got %d bytes, want %d bytes", tt.destination, len(got), len(tt.content))
+			}
+
+			info, err := client.GetFileInfo(ctx, tt.destination)
+			if err != nil {
+				t.Fatalf("failed to get the file info: %s", err)
+			}
+			if info.Path != tt.destination || info.Size != len(tt.content) {
+				t.Errorf("file info: got %s with %d bytes, want %s with %d bytes", info.Path, info.Size, tt.destination, len(tt.content))
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A source document that was converted by a job
+type Entry struct {
+	// Unique ID of the source (nextcloud file ID or BookStack book ID)
+	SourceID   string `json:"sourceId"`
+	SourcePath string `json:"sourcePath"`
+	// Version of the source at the time of the conversion
+	ETag string `json:"etag"`
+	Size int    `json:"size"`
+
+	// Path of the converted file relative to the root of the storage
+	Destination string    `json:"destination"`
+	ConvertedAt time.Time `json:"convertedAt"`
+}
+
+// Persistent store of the converted documents of all jobs.
+// The state is saved as a JSON file inside the data directory
+type Store struct {
+	path string
+	mut  sync.Mutex
+
+	// Entries indexed by the job key and the source ID
+	jobs map[string]map[string]Entry
+}
+
+// Opens the state file inside the given data directory.
+// If the file does not exist, an empty store is returned
+func Open(dataDir string) (*Store, error) {
+	store := &Store{
+		path: filepath.Join(dataDir, "state.json"),
+		jobs: make(map[string]map[string]Entry),
+	}
+
+	content, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the state file '%s': %s", store.path, err)
+	}
+
+	if err := json.Unmarshal(content, &store.jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse the state file '%s': %s", store.path, err)
+	}
+
+	return store, nil
+}
+
+// Returns the path of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Returns the entry of the source with the given ID
+func (s *Store) Get(job string, sourceID string) (Entry, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	entry, exists := s.jobs[job][sourceID]
+	return entry, exists
+}
+
+// Returns a copy of all entries of the job indexed by the source ID
+func (s *Store) Entries(job string) map[string]Entry {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	rtc := make(map[string]Entry, len(s.jobs[job]))
+	for id, entry := range s.jobs[job] {
+		rtc[id] = entry
+	}
+
+	return rtc
+}
+
+// Returns the keys of all jobs with saved entries
+func (s *Store) Jobs() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	rtc := make([]string, 0, len(s.jobs))
+	for job := range s.jobs {
+		rtc = append(rtc, job)
+	}
+
+	return rtc
+}
+
+// Adds or replaces the entry of a source
+func (s *Store) Set(job string, entry Entry) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.jobs[job] == nil {
+		s.jobs[job] = make(map[string]Entry)
+	}
+	s.jobs[job][entry.SourceID] = entry
+}
+
+// Removes the entry of a source
+func (s *Store) Delete(job string, sourceID string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.jobs[job], sourceID)
+	if len(s.jobs[job]) == 0 {
+		delete(s.jobs, job)
+	}
+}
+
+// Writes the state to the disk
+func (s *Store) Save() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	content, err := json.MarshalIndent(s.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create the data directory: %s", err)
+	}
+
+	// Write to a temporary file first to not corrupt the state on a crash
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write the state file: %s", err)
+	}
+
+	return os.Rename(tmp, s.path)
+}
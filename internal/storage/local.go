@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+)
+
+// Storage that saves the files in a directory of the local filesystem
+type localStorage struct {
+	root string
+}
+
+func NewLocal(root string) (Storage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("no path given for the local storage")
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStorage{root: root}, nil
+}
+
+// Returns the absolute path on the filesystem for the relative path of the storage
+func (s *localStorage) getPath(path string) (string, error) {
+	rtc := filepath.Join(s.root, filepath.FromSlash(path))
+	if rtc != s.root && !strings.HasPrefix(rtc, s.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("the path '%s' is outside of the storage root", path)
+	}
+
+	return rtc, nil
+}
+
+func (s *localStorage) List(ctx context.Context, directory string, contentType []string) (map[string]File, error) {
+	dir, err := s.getPath(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+
+	rtc := make(map[string]File)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		file, err := s.getFile(path)
+		if err != nil {
+			return err
+		}
+		if !containsContentType(contentType, file.ContentType) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		rtc[rel[0:len(rel)-len(filepath.Ext(rel))]] = *file
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rtc, nil
+}
+
+func (s *localStorage) Stat(ctx context.Context, path string) (*File, error) {
+	p, err := s.getPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.getFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+
+	return file, err
+}
+
+// Returns the file details of the given absolute path
+func (s *localStorage) getFile(path string) (*File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Path:         filepath.ToSlash(rel),
+		LastModified: info.ModTime(),
+		ContentType:  getContentType(filepath.Ext(path)),
+		Size:         int(info.Size()),
+	}, nil
+}
+
+func (s *localStorage) Put(ctx context.Context, path string, content io.Reader, size int64, version string) error {
+	p, err := s.getPath(path)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file %s: %s", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localStorage) Delete(ctx context.Context, path string) error {
+	p, err := s.getPath(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("failed to delete file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Move(ctx context.Context, source string, destination string) error {
+	src, err := s.getPath(source)
+	if err != nil {
+		return err
+	}
+	dest, err := s.getPath(destination)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dest); err != nil {
+		return fmt.Errorf("failed to move file %s to %s: %s", source, destination, err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Mkdir(ctx context.Context, path string) error {
+	p, err := s.getPath(path)
+	if err != nil {
+		return err
+	}
+
+	return os.MkdirAll(p, 0755)
+}
+
+// Returns the content type for the given file extension (".pdf").
+// The system mime types are not always available (e.g. inside containers) → the types
+// of the formats written by the converters are looked up first
+func getContentType(extension string) string {
+	extension = strings.ToLower(strings.TrimPrefix(extension, "."))
+	for _, format := range models.OfficeFormats {
+		if format.Extension() == extension {
+			return format.ContentType()
+		}
+	}
+
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension("." + extension))
+	return contentType
+}
+
+// Returns true if the content type is contained in the given types
+func containsContentType(types []string, contentType string) bool {
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Storage that saves the files inside the nextcloud of the user or on a generic WebDAV server
+type ncStorage struct {
+	client *nextcloud.Client
+	// Identifies the uploads of the job
+	key string
+}
+
+func NewNextcloud(client *nextcloud.Client, key string) Storage {
+	return &ncStorage{client: client, key: key}
+}
+
+func (s *ncStorage) List(ctx context.Context, directory string, contentType []string) (map[string]File, error) {
+	result, err := s.client.SearchInDirectory(ctx, directory, contentType, true)
+	if errors.Is(err, nextcloud.ErrNotFound) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	rtc := make(map[string]File)
+	for index, file := range nextcloud.ParseSearchResult(result, s.client.HrefPrefix(), directory) {
+		rtc[index] = fromNcFile(&file)
+	}
+
+	return rtc, nil
+}
+
+func (s *ncStorage) Stat(ctx context.Context, path string) (*File, error) {
+	file, err := s.client.GetFileInfo(ctx, path)
+	if errors.Is(err, nextcloud.ErrNotFound) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	rtc := fromNcFile(file)
+	return &rtc, nil
+}
+
+func (s *ncStorage) Put(ctx context.Context, path string, content io.Reader, size int64, version string) error {
+	return s.client.UploadFile(ctx, path, content, size, s.key+"\n"+version)
+}
+
+func (s *ncStorage) Delete(ctx context.Context, path string) error {
+	return s.client.DeleteFile(ctx, path)
+}
+
+func (s *ncStorage) Move(ctx context.Context, source string, destination string) error {
+	return s.client.MoveFile(ctx, source, destination)
+}
+
+func (s *ncStorage) Mkdir(ctx context.Context, path string) error {
+	// The last path element is treated as a file name
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	return s.client.CreateFoldersRecursively(ctx, path)
+}
+
+func fromNcFile(file *nextcloud.NcFile) File {
+	return File{
+		Path:         file.Path,
+		LastModified: file.LastModified,
+		ContentType:  file.ContentType,
+		Size:         file.Size,
+	}
+}
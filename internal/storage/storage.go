@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"git.rpjosh.de/ncDocConverter/internal/models"
+	"git.rpjosh.de/ncDocConverter/internal/nextcloud"
+)
+
+// Returned by Stat if the requested file does not exist
+var ErrNotExist = errors.New("file does not exist")
+
+// The internal representation of a file inside a storage
+type File struct {
+	// Relative path of the file to the root of the storage: folder/file.txt
+	Path         string
+	LastModified time.Time
+	ContentType  string
+	// Size in Bytes
+	Size int
+}
+
+// A backend to save the converted files in.
+// All paths are relative to the root of the storage (ebook/folder/file.txt)
+type Storage interface {
+	// Returns all files of the given content types starting in the given directory.
+	// A map with the relative path based on the directory without the file
+	// extension ("someFolder/file") is returned.
+	// If the directory does not exist ErrNotExist is returned
+	List(ctx context.Context, directory string, contentType []string) (map[string]File, error)
+
+	// Returns the details of a single file. If the file does not exist
+	// ErrNotExist is returned
+	Stat(ctx context.Context, path string) (*File, error)
+
+	// Saves the content with the given size in bytes (-1 if unknown) to the given path.
+	// The version identifies the source of the content (e.g. its ETag). An interrupted upload
+	// is only resumed for the same version. An existing file will be overwritten
+	Put(ctx context.Context, path string, content io.Reader, size int64, version string) error
+
+	// Deletes the file with the given path
+	Delete(ctx context.Context, path string) error
+
+	// Moves or renames a file. An existing file at the destination will be overwritten
+	Move(ctx context.Context, source string, destination string) error
+
+	// Creates the given directory including all parent directories
+	Mkdir(ctx context.Context, path string) error
+}
+
+// Returns the storage configured for a job. When no type is given,
+// the files are saved in the nextcloud of the user.
+// The client is used for the types "nextcloud" and "webdav".
+// The key identifies the job, so that its interrupted uploads can be resumed
+func New(config models.Storage, client *nextcloud.Client, key string) (Storage, error) {
+	switch config.Type {
+	case "", models.NextcloudStorage, models.WebDAVStorage:
+		return NewNextcloud(client, key), nil
+	case models.LocalStorage:
+		return NewLocal(config.Path)
+	default:
+		return nil, fmt.Errorf("invalid storage type given: '%s'. Expected 'nextcloud', 'webdav' or 'local'", config.Type)
+	}
+}
@@ -25,6 +25,17 @@ func CopyMap[T comparable, Val any](m map[T]Val) map[T]Val {
 	return cp
 }
 
+// Returns true if the slice contains the value
+func Contains[T comparable](slice []T, value T) bool {
+	for _, v := range slice {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Copies a struct
 func Copy(source interface{}, destin interface{}) {
 	x := reflect.ValueOf(source)